@@ -0,0 +1,93 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"sync"
+)
+
+// Document is parsed YAML that can be searched for many times. The YAML is
+// parsed once, and every result, along with the values iterated by
+// ForEach, Array and Map, reads from the same parsed tree. A Document is
+// safe for concurrent use by multiple goroutines.
+type Document struct {
+	src  *source
+	root *node
+
+	// docs are the documents of the stream, parsed on the first path with
+	// the ".." prefix, up to the first invalid one, whose error is streamErr
+	once      sync.Once
+	docs      []*node
+	streamErr error
+}
+
+// ParseDocument parses yaml into a Document. A *SyntaxError is returned if
+// the first document of the yaml is not valid.
+//
+//	doc, err := gyaml.ParseDocument(config)
+//	if err != nil {
+//		return err
+//	}
+//	host := doc.Get("server.host").String()
+//	port := doc.Get("server.port").Int()
+func ParseDocument(yaml string) (*Document, error) {
+	src := newSource(yaml)
+	root, err := parseSource(src)
+	if err != nil {
+		return nil, &SyntaxError{Err: err}
+	}
+	return &Document{src: src, root: root}, nil
+}
+
+// ParseDocumentBytes parses yaml into a Document.
+// If working with bytes, this method preferred over
+// ParseDocument(string(data)).
+func ParseDocumentBytes(yaml []byte) (*Document, error) {
+	return ParseDocument(string(yaml))
+}
+
+// Get searches the document for the specified path.
+func (d *Document) Get(path string) Result {
+	res, _ := d.get(newPath(path))
+	return res
+}
+
+// GetE searches the document for the specified path, and returns an error
+// when the value is not found. See GetE for the types of errors.
+func (d *Document) GetE(path string) (Result, error) {
+	return d.get(newPath(path))
+}
+
+// GetPath searches the document for a compiled path.
+func (d *Document) GetPath(p *Path) Result {
+	res, _ := d.get(p)
+	return res
+}
+
+// ForEach iterates through the values of the first document of the yaml.
+// See Result.ForEach.
+func (d *Document) ForEach(iterator func(key, value Result) bool) {
+	d.Get("").ForEach(iterator)
+}
+
+func (d *Document) get(p *Path) (Result, error) {
+	if p.stream {
+		d.once.Do(func() {
+			d.docs, d.streamErr = parseStream(d.src)
+		})
+		res, err := getFromPath(newSequence(d.docs), p, d.src.text)
+		if err != nil {
+			return res, err
+		}
+		return res, d.streamErr
+	}
+	if p.path == "" {
+		// empty path returns the entire yaml
+		res := Result{Type: YAML, Raw: d.src.text, node: d.root, at: location{known: true}}
+		res.Index, res.Line, res.Column = d.src.position(0)
+		return res, nil
+	}
+	return getFromPath(d.root, p, d.src.text)
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SyntaxError is returned when the yaml is not valid.
+type SyntaxError struct {
+	// Err is the error reported by the YAML parser
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return "gyaml: invalid yaml: " + strings.TrimPrefix(e.Err.Error(), "yaml: ")
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// KeyError is returned when a segment of a path is not found in the yaml,
+// such as a missing key, or a key of a value that is not a mapping.
+type KeyError struct {
+	// Path is the path that was searched
+	Path string
+	// Segment is the segment of the path that was not found
+	Segment string
+	// Reason tells why the segment was not found
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("gyaml: path %q: segment %q not found: %s", e.Path, e.Segment, e.Reason)
+}
+
+// IndexError is returned when an index of a path is out of the range of
+// a sequence.
+type IndexError struct {
+	// Path is the path that was searched
+	Path string
+	// Segment is the segment of the path holding the index
+	Segment string
+	// Index is the index that was requested
+	Index int
+	// Len is the length of the sequence
+	Len int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("gyaml: path %q: segment %q: index %d out of range for sequence of length %d",
+		e.Path, e.Segment, e.Index, e.Len)
+}
+
+// QueryError is returned when a #(...) query, or a {...} or [...]
+// multipath, of a path is malformed.
+type QueryError struct {
+	// Path is the path that was searched
+	Path string
+	// Segment is the segment of the path holding the query
+	Segment string
+	// Reason tells what is wrong with the query
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("gyaml: path %q: segment %q: malformed query: %s", e.Path, e.Segment, e.Reason)
+}
+
+// ModifierError is returned when a path uses a modifier that does not
+// exist, or a modifier whose argument is not valid.
+type ModifierError struct {
+	// Path is the path that was searched
+	Path string
+	// Segment is the segment of the path holding the modifier
+	Segment string
+	// Name is the name of the modifier, without the '@'
+	Name string
+	// Reason tells what is wrong with the argument, and is empty for an
+	// unknown modifier
+	Reason string
+}
+
+func (e *ModifierError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("gyaml: path %q: segment %q: modifier %q: %s", e.Path, e.Segment, e.Name, e.Reason)
+	}
+	return fmt.Sprintf("gyaml: path %q: segment %q: unknown modifier %q", e.Path, e.Segment, e.Name)
+}
+
+// PathError is returned by Set and Delete when a path can't be edited,
+// such as an empty path, or a path holding a wildcard or a query.
+type PathError struct {
+	// Path is the path that was edited
+	Path string
+	// Segment is the segment of the path that can't be edited
+	Segment string
+	// Reason tells why the segment can't be edited
+	Reason string
+}
+
+func (e *PathError) Error() string {
+	if e.Segment == "" {
+		return fmt.Sprintf("gyaml: path %q: %s", e.Path, e.Reason)
+	}
+	return fmt.Sprintf("gyaml: path %q: segment %q: %s", e.Path, e.Segment, e.Reason)
+}
+
+// isMissing returns true if the error tells that a value does not exist,
+// rather than that the yaml or the path is broken.
+func isMissing(err error) bool {
+	var keyErr *KeyError
+	var indexErr *IndexError
+	return errors.As(err, &keyErr) || errors.As(err, &indexErr)
+}
+
+// withPath sets the path on the errors found while searching it.
+func withPath(err error, path string) error {
+	switch e := err.(type) {
+	case *KeyError:
+		e.Path = path
+	case *IndexError:
+		e.Path = path
+	case *QueryError:
+		e.Path = path
+	case *ModifierError:
+		e.Path = path
+	case *PathError:
+		e.Path = path
+	}
+	return err
+}
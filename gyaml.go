@@ -6,7 +6,8 @@
 package gyaml
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
@@ -65,9 +66,33 @@ type Result struct {
 	Num float64
 	// Index of raw value in original yaml, zero means index unknown
 	Index int
+	// Line of the value in the original yaml, starting at 1. Zero means
+	// the line is unknown.
+	Line int
+	// Column of the value in the original yaml, in characters starting at
+	// 1. Zero means the column is unknown.
+	Column int
 	// Indexes of all the elements that match on a path containing the '#'
-	// query character.
+	// query character, as byte offsets in the original yaml. They are the
+	// Index that each element has on its own.
 	Indexes []int
+	// Paths are the resolved paths of all the elements that match on a
+	// path containing the '#' query character, like friends.0.first, in
+	// the order of Indexes. They are nil when an element is not in the
+	// original yaml, like the output of a modifier.
+	Paths []string
+	// Alias is the name of the alias, or of the merge key source, that the
+	// value was reached through. It's empty when the value was not reached
+	// through an alias.
+	Alias string
+
+	// node is the parsed value, which children are read from
+	node *node
+	// present is true for a null value that is in the yaml, which may have
+	// an empty Raw, like the value of "key:"
+	present bool
+	// at is the resolved path of the value
+	at location
 }
 
 // String returns a string representation of the value.
@@ -207,11 +232,17 @@ func (t Result) Array() []Result {
 
 // IsObject returns true if the result value is a YAML object.
 func (t Result) IsObject() bool {
+	if t.node != nil {
+		return t.node.kind == mappingNode
+	}
 	return t.Type == YAML && len(t.Raw) > 0 && (t.Raw[0] == '{' || isYAMLObject(t.Raw))
 }
 
 // IsArray returns true if the result value is a YAML array.
 func (t Result) IsArray() bool {
+	if t.node != nil {
+		return t.node.kind == sequenceNode
+	}
 	return t.Type == YAML && len(t.Raw) > 0 && (t.Raw[0] == '[' || isYAMLArray(t.Raw))
 }
 
@@ -236,27 +267,28 @@ func (t Result) ForEach(iterator func(key, value Result) bool) {
 	}
 
 	// Parse the YAML to determine structure
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(t.Raw), &data); err != nil {
+	n := t.tree()
+	if n == nil {
 		return
 	}
 
-	switch v := data.(type) {
-	case map[string]interface{}:
+	switch n.kind {
+	case mappingNode:
 		// Object iteration
-		for key, val := range v {
-			keyResult := Result{Type: String, Str: key, Raw: key}
-			valResult := valueToResult(val)
-			if !iterator(keyResult, valResult) {
-				return
-			}
-		}
-	case []interface{}:
+		i := 0
+		n.pairs(func(key, value *node) bool {
+			res := value.result()
+			res.at = childLocation(n, t.at, i, key)
+			i++
+			return iterator(key.keyResult(), res)
+		})
+	case sequenceNode:
 		// Array iteration
-		for i, val := range v {
+		for i, item := range n.items() {
 			keyResult := Result{Type: Number, Num: float64(i)}
-			valResult := valueToResult(val)
-			if !iterator(keyResult, valResult) {
+			res := item.result()
+			res.at = childLocation(n, t.at, i, nil)
+			if !iterator(keyResult, res) {
 				return
 			}
 		}
@@ -279,15 +311,30 @@ func (t Result) Map() map[string]Result {
 // Get searches result for the specified path.
 // The result should be a YAML array or object.
 func (t Result) Get(path string) Result {
-	r := Get(t.Raw, path)
-	if r.Indexes != nil {
-		for i := 0; i < len(r.Indexes); i++ {
-			r.Indexes[i] += t.Index
-		}
-	} else {
-		r.Index += t.Index
+	return t.GetPath(newPath(path))
+}
+
+// source returns the raw yaml of the result, located where the result is
+// in the original yaml.
+func (t Result) source() *source {
+	src := newSource(t.Raw)
+	if t.Line > 0 {
+		// the raw yaml of a block collection starts with the indentation of
+		// its first line
+		indent := len(t.Raw) - len(strings.TrimLeft(t.Raw, " "))
+		src.offset, src.line, src.column = t.Index, t.Line, t.Column-indent
 	}
-	return r
+	return src
+}
+
+// tree returns the parsed value of the result, or nil if the raw yaml is
+// not valid.
+func (t Result) tree() *node {
+	if t.node != nil {
+		return t.node
+	}
+	n, _ := parseSource(t.source())
+	return n
 }
 
 type arrayOrMapResult struct {
@@ -300,30 +347,37 @@ type arrayOrMapResult struct {
 
 func (t Result) arrayOrMap(vc byte, valueize bool) (r arrayOrMapResult) {
 	// Parse YAML to get structure
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(t.Raw), &data); err != nil {
+	n := t.tree()
+	if n == nil {
 		return
 	}
 
-	switch v := data.(type) {
-	case map[string]interface{}:
+	switch n.kind {
+	case mappingNode:
 		r.vc = '{'
 		if valueize {
-			r.oi = v
+			r.oi, _ = n.decode().(map[string]interface{})
 		} else {
-			r.o = make(map[string]Result)
-			for key, val := range v {
-				r.o[key] = valueToResult(val)
-			}
+			r.o = make(map[string]Result, n.size())
+			i := 0
+			n.pairs(func(key, value *node) bool {
+				res := value.result()
+				res.at = childLocation(n, t.at, i, key)
+				r.o[key.value] = res
+				i++
+				return true
+			})
 		}
-	case []interface{}:
+	case sequenceNode:
 		r.vc = '['
 		if valueize {
-			r.ai = v
+			r.ai, _ = n.decode().([]interface{})
 		} else {
-			r.a = make([]Result, len(v))
-			for i, val := range v {
-				r.a[i] = valueToResult(val)
+			items := n.items()
+			r.a = make([]Result, len(items))
+			for i, item := range items {
+				r.a[i] = item.result()
+				r.a[i].at = childLocation(n, t.at, i, nil)
 			}
 		}
 	}
@@ -331,13 +385,28 @@ func (t Result) arrayOrMap(vc byte, valueize bool) (r arrayOrMapResult) {
 	return r
 }
 
-// Exists returns true if value exists.
+// Exists returns true if value exists. A null value in the yaml exists,
+// even the value of "key:" with nothing after the colon. Use IsNull to tell
+// it apart from other values.
 //
 //	if gjson.Get(yaml, "name.last").Exists() {
 //		println("has a last name")
 //	}
 func (t Result) Exists() bool {
-	return t.Type != Null || len(t.Raw) != 0
+	return t.Type != Null || len(t.Raw) != 0 || t.present
+}
+
+// IsNull returns true if the result is a null value that exists in the
+// yaml, like the values of "key: null", "key: ~" and "key:". A value that
+// does not exist is not null.
+//
+//	if res := gyaml.Get(overlay, "replicas"); res.IsNull() {
+//		// explicitly unset
+//	} else if !res.Exists() {
+//		// not provided
+//	}
+func (t Result) IsNull() bool {
+	return t.Type == Null && t.Exists()
 }
 
 // Value returns one of these types:
@@ -348,6 +417,8 @@ func (t Result) Exists() bool {
 //	nil, for YAML null
 //	[]interface{}, for YAML arrays
 //	map[string]interface{}, for YAML objects
+//
+// Use OrderedValue to keep the keys of YAML objects in document order.
 func (t Result) Value() interface{} {
 	switch t.Type {
 	default:
@@ -361,12 +432,86 @@ func (t Result) Value() interface{} {
 	case True:
 		return true
 	case YAML:
-		var data interface{}
-		if err := yamlv3.Unmarshal([]byte(t.Raw), &data); err != nil {
-			return nil
+		if n := t.tree(); n != nil {
+			return n.decode()
+		}
+		return nil
+	}
+}
+
+// OrderedValue is like Value, but returns YAML objects as a MapSlice,
+// which keeps their keys in document order.
+func (t Result) OrderedValue() interface{} {
+	if t.Type != YAML {
+		return t.Value()
+	}
+	if n := t.tree(); n != nil {
+		return n.decodeOrdered()
+	}
+	return nil
+}
+
+// MapSlice is a YAML object that keeps its keys in document order. It's
+// encoded to YAML and JSON in that order.
+type MapSlice []MapItem
+
+// MapItem is a key and value of a MapSlice.
+type MapItem struct {
+	Key   string
+	Value interface{}
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (m MapSlice) MarshalYAML() (interface{}, error) {
+	n := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	for _, item := range m {
+		var key, value yamlv3.Node
+		if err := key.Encode(item.Key); err != nil {
+			return nil, err
+		}
+		if err := value.Encode(item.Value); err != nil {
+			return nil, err
+		}
+		n.Content = append(n.Content, &key, &value)
+	}
+	return n, nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m MapSlice) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, item := range m {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
 		}
-		return data
+		buf = append(append(append(buf, key...), ':'), value...)
 	}
+	return append(buf, '}'), nil
+}
+
+// Path returns the resolved path of the value, which Get finds the value
+// at again. Its components are escaped and its indexes are concrete, like
+// spec.containers.1.image for a value found with a query. The path of the
+// root is "@this", and the path of a value in a document stream starts
+// with "..". An empty string is returned when the value is not in the yaml,
+// like the output of a modifier or the results of a # query, whose
+// elements are located by Paths.
+func (t Result) Path() string {
+	if !t.at.known {
+		return ""
+	}
+	if t.at.path == "" {
+		return "@this"
+	}
+	return t.at.path
 }
 
 // Less return true if a token is less than another token.
@@ -426,17 +571,6 @@ func stringLessInsensitive(a, b string) bool {
 	return len(a) < len(b)
 }
 
-// parseContext is used during parsing
-type parseContext struct {
-	yaml   string
-	value  Result
-	pipe   string
-	piped  bool
-	calcd  bool
-	lines  bool
-	yamlma map[string]interface{}
-}
-
 // Get searches yaml for the specified path.
 // A path is in dot syntax, such as "name.last" or "age".
 // This function expects that the yaml is well-formed and validates. Bad yaml will not panic,
@@ -456,42 +590,70 @@ type parseContext struct {
 //	"children.0"         >> "Sara"
 //	"children.1"         >> "Alex"
 func Get(yaml, path string) Result {
-	if len(path) > 1 && path[0] == '.' && path[1] == '.' {
-		return getMany(yaml, path)
-	}
+	res, _ := get(newSource(yaml), newPath(path), false)
+	return res
+}
 
-	if len(path) == 0 {
-		// empty path returns the entire yaml
-		return Result{
-			Type:  YAML,
-			Raw:   yaml,
-			Index: 0,
-		}
+// GetE searches yaml for the specified path, like Get, and returns an
+// error when the value is not found. The error tells which segment of the
+// path failed and why, and has one of these types:
+//
+//	*SyntaxError, when the yaml is not valid
+//	*KeyError, when a key, or any other segment, is not found
+//	*IndexError, when an index is out of range
+//	*QueryError, when a #(...) query is malformed
+//	*ModifierError, when a modifier does not exist
+//
+// A *KeyError or *IndexError means the value is missing, while the other
+// types mean the yaml or the path is broken.
+//
+//	res, err := gyaml.GetE(yaml, "name.last")
+//	var keyErr *gyaml.KeyError
+//	if errors.As(err, &keyErr) {
+//		println("no last name")
+//	}
+func GetE(yaml, path string) (Result, error) {
+	return newPath(path).GetE(yaml)
+}
+
+// get searches the yaml of a source for the specified path. A result is
+// returned along with the error of a stream holding invalid documents.
+// The fast path, which doesn't read the whole document, is only taken when
+// checked is false, so that the E variants report an invalid document.
+func get(src *source, p *Path, checked bool) (Result, error) {
+	yaml := src.text
+	if p.stream {
+		return getMany(src, p)
 	}
 
-	if path[0] == '.' {
-		// path starts with dot, remove it
-		path = path[1:]
+	if len(p.path) == 0 {
+		if checked {
+			if _, err := parseSource(src); err != nil {
+				return Result{}, &SyntaxError{Err: err}
+			}
+		}
+		// empty path returns the entire yaml
+		res := Result{Type: YAML, Raw: yaml, at: location{known: true}}
+		res.Index, res.Line, res.Column = src.position(0)
+		return res, nil
 	}
 
-	// Try fast path first for simple queries
-	if result, ok := fastGet(yaml, path); ok {
-		return result
+	// Try fast path first for simple queries, which only looks at the
+	// first document of a stream
+	if p.keys != nil && !checked {
+		if result, ok := fastGet(src, p.keys); ok {
+			return result, nil
+		}
 	}
 
 	// Fall back to slow path for complex queries
-	var c parseContext
-	c.yaml = yaml
-
-	// Convert YAML to a normalized form for easier parsing
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yaml), &data); err != nil {
-		return c.value
+	root, err := parseSource(src)
+	if err != nil {
+		return Result{}, &SyntaxError{Err: err}
 	}
-	c.yamlma = make(map[string]interface{})
 
 	// Now traverse the path
-	return getFromPath(data, path, yaml)
+	return getFromPath(root, p, yaml)
 }
 
 // GetBytes searches yaml for the specified path.
@@ -501,23 +663,47 @@ func GetBytes(yaml []byte, path string) Result {
 }
 
 // GetMany searches yaml for multiple paths.
-// The return value is a Result holding a YAML array of values.
-// An empty array is returned if the yaml is not valid.
+// The return value is a Result holding a YAML sequence of the values, in
+// the order of the paths, with a null for every path that is not found.
+// The yaml is parsed once for all the paths. An empty sequence is returned
+// if the yaml is not valid.
 func GetMany(yaml string, path ...string) Result {
-	var res Result
-	res.Type = YAML
-	var data []byte
-	data = append(data, '[')
-	for i, path := range path {
-		if i > 0 {
-			data = append(data, ',')
+	items := make([]*node, 0, len(path))
+	locs := make([]location, 0, len(path))
+	for _, res := range getManyResults(yaml, path) {
+		if res.node == nil {
+			res.node = &node{kind: scalarNode, tag: "!!null", value: "null"}
 		}
-		val := Get(yaml, path)
-		data = append(data, val.Raw...)
+		items = append(items, res.node)
+		locs = append(locs, res.at)
 	}
-	data = append(data, ']')
-	res.Raw = string(data)
-	return res
+	return withPaths(newSequence(items), locs).result()
+}
+
+// GetManyResults searches yaml for multiple paths, and returns a Result
+// for every path, which doesn't exist when the path is not found. The yaml
+// is parsed once for all the paths.
+func GetManyResults(yaml string, path ...string) []Result {
+	results := getManyResults(yaml, path)
+	if results == nil {
+		// the yaml is not valid
+		results = make([]Result, len(path))
+	}
+	return results
+}
+
+// getManyResults returns the results of the paths, or nil if the yaml is
+// not valid.
+func getManyResults(yaml string, paths []string) []Result {
+	doc, err := ParseDocument(yaml)
+	if err != nil {
+		return nil
+	}
+	results := make([]Result, len(paths))
+	for i, path := range paths {
+		results[i] = doc.Get(path)
+	}
+	return results
 }
 
 // GetManyBytes searches yaml for multiple paths.
@@ -526,25 +712,138 @@ func GetManyBytes(yaml []byte, path ...string) Result {
 	return GetMany(string(yaml), path...)
 }
 
-func getMany(yaml, path string) Result {
-	// Handle lines (..) prefix
-	var data []interface{}
-	lines := strings.Split(yaml, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// getMany evaluates a path prefixed with ".." against the documents of a
+// YAML stream, which are treated as an array.
+func getMany(src *source, p *Path) (Result, error) {
+	docs, syntaxErr := parseStream(src)
+	res, err := getFromPath(newSequence(docs), p, src.text)
+	if err != nil {
+		return res, err
+	}
+	return res, syntaxErr
+}
+
+// parseStream parses the documents of a YAML stream. The parsing stops at
+// the first invalid document, and its error is returned along with the
+// documents before it.
+func parseStream(src *source) ([]*node, error) {
+	yaml := src.text
+	var docs []*node
+	for _, doc := range splitDocuments(yaml) {
+		docSrc := newSource(yaml[doc.start:doc.end])
+		docSrc.offset, docSrc.line, docSrc.column = src.position(doc.start)
+		item, err := parseStreamDocument(docSrc)
+		if err != nil {
+			return docs, &SyntaxError{Err: err}
 		}
-		var item interface{}
-		if err := yamlv3.Unmarshal([]byte(line), &item); err != nil {
-			continue
+		if item == nil {
+			// an empty document is a null, at the start of the document
+			item = &node{kind: scalarNode, tag: "!!null", src: docSrc}
+		}
+		docs = append(docs, item)
+	}
+	return docs, nil
+}
+
+// GetDocuments returns every document of a YAML stream.
+// Documents are separated by "---" lines. Each result holds the raw text
+// of one document and its Index in the stream. An explicitly empty
+// document is returned as a Null result.
+//
+//	for _, doc := range gyaml.GetDocuments(manifests) {
+//		println(doc.Get("kind").String())
+//	}
+func GetDocuments(yaml string) []Result {
+	var docs []Result
+	ForEachDocument(yaml, func(doc Result) bool {
+		docs = append(docs, doc)
+		return true
+	})
+	return docs
+}
+
+// ForEachDocument iterates through the documents of a YAML stream.
+// Returning false from the iterator stops the iteration.
+func ForEachDocument(yaml string, iterator func(doc Result) bool) {
+	src := newSource(yaml)
+	for i, doc := range splitDocuments(yaml) {
+		res := Result{Type: Null, present: true, at: location{".." + strconv.Itoa(i), true}}
+		res.Index, res.Line, res.Column = src.position(doc.start)
+		if raw := yaml[doc.start:doc.end]; hasContent(raw) {
+			res.Type = YAML
+			res.Raw = raw
+		}
+		if !iterator(res) {
+			return
+		}
+	}
+}
+
+// document is the byte range of one document in a YAML stream.
+type document struct {
+	start, end int
+}
+
+// splitDocuments splits a YAML stream on its "---" and "..." marker lines.
+// Content before the first marker is a document only when it holds more
+// than comments and directives, while every "---" starts a document,
+// even an empty one.
+func splitDocuments(yaml string) []document {
+	var docs []document
+	start, explicit := 0, false
+	for i := 0; i < len(yaml); {
+		end := strings.IndexByte(yaml[i:], '\n')
+		if end == -1 {
+			end = len(yaml)
+		} else {
+			end += i
+		}
+		if isDocumentMarker(yaml[i:end]) {
+			if explicit || hasContent(yaml[start:i]) {
+				docs = append(docs, document{start, i})
+			}
+			start, explicit = end+1, false
+			if yaml[i] == '-' {
+				// content may follow the marker on the same line
+				explicit = true
+				start = i + 3
+				for start < end && (yaml[start] == ' ' || yaml[start] == '\t') {
+					start++
+				}
+				if start == end {
+					start = end + 1
+				}
+			}
+			if start > len(yaml) {
+				start = len(yaml)
+			}
 		}
-		data = append(data, item)
+		i = end + 1
+	}
+	if explicit || hasContent(yaml[start:]) {
+		docs = append(docs, document{start, len(yaml)})
+	}
+	return docs
+}
+
+// isDocumentMarker returns true if the line is a "---" or "..." marker.
+func isDocumentMarker(line string) bool {
+	if !strings.HasPrefix(line, "---") && !strings.HasPrefix(line, "...") {
+		return false
 	}
+	return len(line) == 3 || line[3] == ' ' || line[3] == '\t' || line[3] == '\n' || line[3] == '\r'
+}
 
-	// Remove the .. prefix
-	path = path[2:]
-	return getFromPath(data, path, yaml)
+// hasContent returns true if the text holds more than blank lines,
+// comments and directives.
+func hasContent(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '#' && line[0] != '%' {
+			return true
+		}
+	}
+	return false
 }
 
 // Parse parses the yaml and returns a result.
@@ -554,9 +853,19 @@ func Parse(yaml string) Result {
 	var res Result
 	res.Type = YAML
 	res.Raw = yaml
+	res.at.known = true
 	return res
 }
 
+// ParseE parses the yaml and returns a result, like Parse. A *SyntaxError
+// is returned if a document of the yaml is not valid.
+func ParseE(yaml string) (Result, error) {
+	if _, err := parseStream(newSource(yaml)); err != nil {
+		return Result{}, err
+	}
+	return Parse(yaml), nil
+}
+
 // ParseBytes parses the yaml and returns a result.
 // If working with bytes, this method preferred over Parse(string(data)).
 func ParseBytes(yaml []byte) Result {
@@ -609,6 +918,7 @@ func valueToResult(val interface{}) Result {
 	switch v := val.(type) {
 	case nil:
 		res.Type = Null
+		res.Raw = "null"
 	case bool:
 		if v {
 			res.Type = True
@@ -646,8 +956,9 @@ func valueToResult(val interface{}) Result {
 }
 
 func isYAMLObject(s string) bool {
-	// Simple heuristic: if it contains ": " it's likely an object
-	return strings.Contains(s, ": ")
+	// Simple heuristic: if it contains ": " and is not an array it's
+	// likely an object
+	return !isYAMLArray(s) && (strings.Contains(s, ": ") || strings.Contains(s, ":\n"))
 }
 
 func isYAMLArray(s string) bool {
@@ -656,23 +967,48 @@ func isYAMLArray(s string) bool {
 }
 
 // getFromPath traverses a parsed YAML structure using a path
-func getFromPath(data interface{}, path string, origYAML string) Result {
+func getFromPath(data *node, p *Path, origYAML string) (Result, error) {
+	n, at, err := p.eval(data, origYAML)
+	if err != nil {
+		return Result{}, withPath(err, p.text)
+	}
+	res := n.result()
+	res.at = at
+	if p.stream {
+		// the documents of a stream are found with the ".." prefix
+		res.at = location{".." + at.path, at.known}
+		if res.Paths != nil {
+			paths := make([]string, len(res.Paths))
+			for i, path := range res.Paths {
+				paths[i] = ".." + path
+			}
+			res.Paths = paths
+		}
+	}
+	return res, nil
+}
+
+// evalPath returns the node found at path.
+func evalPath(data, root *node, path string, origYAML string) (*node, error) {
 	if path == "" || path == "@this" {
-		return valueToResult(data)
+		return data, nil
 	}
 
 	// Handle modifiers
 	if path[0] == '@' {
-		return applyModifier(data, path, origYAML)
+		return applyModifier(data, root, path, origYAML)
 	}
 
 	// Parse path components
-	parts := parsePath(path)
+	parts, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
 	if len(parts) == 0 {
-		return valueToResult(data)
+		return data, nil
 	}
 
-	return traversePath(data, parts, origYAML)
+	return traversePath(data, root, parts)
 }
 
 // pathComponent represents a single component of a path
@@ -681,20 +1017,33 @@ type pathComponent struct {
 	isWild  bool
 	isQuery bool
 	query   string
+	expr    queryExpr
 	isIndex bool
 	index   int
 	isCount bool
-	pipe    string
-	hasPipe bool
-	multi   bool // for #()# queries
-}
-
-func parsePath(path string) []pathComponent {
+	isSlice bool
+	slice   slice
+	// isDescent is true for a recursive descent, ** or ..
+	isDescent bool
+	// isMultipath is true for a {...} or [...] multipath, which builds an
+	// object, or an array, of the values of its selectors
+	isMultipath bool
+	object      bool
+	selectors   []selector
+	pipe        string
+	hasPipe     bool
+	multi       bool // for #()# queries
+	// segment is the text of the component, for errors
+	segment string
+}
+
+func parsePath(path string) ([]pathComponent, error) {
 	var parts []pathComponent
 	var current strings.Builder
-	var inQuery bool
-	var queryDepth int
 	var escaped bool
+	// literal is true when a wildcard character of the current component
+	// was escaped, so that it's a key rather than a pattern
+	var literal bool
 
 	for i := 0; i < len(path); i++ {
 		ch := path[i]
@@ -702,6 +1051,9 @@ func parsePath(path string) []pathComponent {
 		if escaped {
 			current.WriteByte(ch)
 			escaped = false
+			if ch == '*' || ch == '?' {
+				literal = true
+			}
 			continue
 		}
 
@@ -710,60 +1062,67 @@ func parsePath(path string) []pathComponent {
 			continue
 		}
 
-		if ch == '#' && !inQuery {
-			if i+1 < len(path) && path[i+1] == '(' {
-				// Start of query
-				inQuery = true
-				queryDepth = 1
-				i++ // skip the '('
-
-				// Save current key if any
-				if current.Len() > 0 {
-					parts = append(parts, pathComponent{key: current.String()})
-					current.Reset()
-				}
-
-				// Start collecting query
-				parts = append(parts, pathComponent{isQuery: true})
-				continue
-			} else {
+		if ch == '#' {
+			// Save current key if any
+			if current.Len() > 0 {
+				parts = append(parts, parseComponent(current.String(), literal))
+				current.Reset()
+				literal = false
+			}
+			if i+1 == len(path) || path[i+1] != '(' {
 				// It's a count operation
-				if current.Len() > 0 {
-					parts = append(parts, pathComponent{key: current.String()})
-					current.Reset()
-				}
-				parts = append(parts, pathComponent{isCount: true})
+				parts = append(parts, pathComponent{isCount: true, segment: "#"})
 				continue
 			}
-		}
 
-		if inQuery {
-			if ch == '(' {
-				queryDepth++
-			} else if ch == ')' {
-				queryDepth--
-				if queryDepth == 0 {
-					// End of query
-					parts[len(parts)-1].query = current.String()
-					current.Reset()
-					inQuery = false
-
-					// Check for multi query (#()#)
-					if i+1 < len(path) && path[i+1] == '#' {
-						parts[len(parts)-1].multi = true
-						i++
-					}
-					continue
+			// Start of query
+			end := queryEnd(path, i+1)
+			if end == -1 {
+				return nil, &QueryError{Segment: path[i:], Reason: "missing closing parenthesis"}
+			}
+			query := pathComponent{isQuery: true, query: path[i+2 : end]}
+			query.segment = path[i : end+1]
+			i = end
+
+			// Check for multi query (#()#)
+			if i+1 < len(path) && path[i+1] == '#' {
+				query.multi = true
+				query.segment += "#"
+				i++
+			}
+			expr, err := parseQuery(query.query)
+			if err != nil {
+				var queryErr *QueryError
+				if !errors.As(err, &queryErr) {
+					err = &QueryError{Segment: query.segment, Reason: err.Error()}
 				}
+				return nil, err
 			}
-			current.WriteByte(ch)
+			query.expr = expr
+			parts = append(parts, query)
+			continue
+		}
+
+		if (ch == '{' || ch == '[') && current.Len() == 0 {
+			multi, end, err := parseMultipath(path, i)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, multi)
+			i = end
 			continue
 		}
 
 		if ch == '.' {
 			if current.Len() > 0 {
-				parts = append(parts, parseComponent(current.String()))
+				parts = append(parts, parseComponent(current.String(), literal))
 				current.Reset()
+				literal = false
+			}
+			if i+1 < len(path) && path[i+1] == '.' {
+				// ".." in a path is a recursive descent, like "**."
+				parts = append(parts, pathComponent{isDescent: true, segment: ".."})
+				i++
 			}
 			continue
 		}
@@ -771,11 +1130,12 @@ func parsePath(path string) []pathComponent {
 		if ch == '|' {
 			// Pipe for modifiers
 			if current.Len() > 0 {
-				parts = append(parts, parseComponent(current.String()))
+				parts = append(parts, parseComponent(current.String(), literal))
 				current.Reset()
+				literal = false
 			}
 			// Rest is pipe
-			parts = append(parts, pathComponent{pipe: path[i+1:], hasPipe: true})
+			parts = append(parts, pathComponent{pipe: path[i+1:], hasPipe: true, segment: path[i+1:]})
 			break
 		}
 
@@ -783,14 +1143,47 @@ func parsePath(path string) []pathComponent {
 	}
 
 	if current.Len() > 0 {
-		parts = append(parts, parseComponent(current.String()))
+		parts = append(parts, parseComponent(current.String(), literal))
 	}
 
-	return parts
+	return parts, nil
 }
 
-func parseComponent(s string) pathComponent {
-	var comp pathComponent
+// escapeComponent escapes the characters of a key that have a meaning in
+// a path, along with a leading '{' or '[', which starts a multipath.
+func escapeComponent(key string) string {
+	if !strings.ContainsAny(key, ".*?#|@\\") && !strings.HasPrefix(key, "{") && !strings.HasPrefix(key, "[") {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '.', '*', '?', '#', '|', '@', '\\':
+			b.WriteByte('\\')
+		case '{', '[':
+			if i == 0 {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(key[i])
+	}
+	return b.String()
+}
+
+// parseComponent parses a component of a path, which is a key when literal
+// is true rather than a wildcard pattern or a recursive descent.
+func parseComponent(s string, literal bool) pathComponent {
+	comp := pathComponent{segment: s}
+	if literal {
+		comp.key = s
+		return comp
+	}
+
+	// Check for a recursive descent
+	if s == "**" {
+		comp.isDescent = true
+		return comp
+	}
 
 	// Check for wildcard
 	if strings.ContainsAny(s, "*?") {
@@ -799,8 +1192,18 @@ func parseComponent(s string) pathComponent {
 		return comp
 	}
 
-	// Check for index
+	// Check for a slice, keeping the key for mappings too
+	if sl, ok := parseSlice(s); ok {
+		comp.key = s
+		comp.isSlice = true
+		comp.slice = sl
+		return comp
+	}
+
+	// Check for index, keeping the key for mappings with numeric keys. A
+	// negative index counts from the end of a sequence.
 	if idx, err := strconv.Atoi(s); err == nil {
+		comp.key = s
 		comp.isIndex = true
 		comp.index = idx
 		return comp
@@ -810,260 +1213,436 @@ func parseComponent(s string) pathComponent {
 	return comp
 }
 
-func traversePath(data interface{}, parts []pathComponent, origYAML string) Result {
+// slice is a start:end:step slice of a sequence, like the slices of
+// Python. Each of them may be left out.
+type slice struct {
+	start, end, step int
+	hasStart, hasEnd bool
+}
+
+// parseSlice parses a slice like 1:4, -2: or ::2. The step can't be zero.
+func parseSlice(s string) (slice, bool) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return slice{}, false
+	}
+	sl := slice{step: 1}
+	bounds := []*int{&sl.start, &sl.end, &sl.step}
+	for i, f := range fields {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return slice{}, false
+		}
+		*bounds[i] = n
+	}
+	sl.hasStart, sl.hasEnd = fields[0] != "", fields[1] != ""
+	return sl, sl.step != 0
+}
+
+// indexes returns the indexes of the items of a sequence of n items that
+// are in the slice, in the order of the slice.
+func (sl slice) indexes(n int) []int {
+	// bound converts a bound to an index within lo and hi
+	bound := func(i, lo, hi int) int {
+		if i < 0 {
+			i += n
+		}
+		return max(lo, min(i, hi))
+	}
+	var indexes []int
+	if sl.step > 0 {
+		start, end := 0, n
+		if sl.hasStart {
+			start = bound(sl.start, 0, n)
+		}
+		if sl.hasEnd {
+			end = bound(sl.end, 0, n)
+		}
+		for i := start; i < end; i += sl.step {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+	start, end := n-1, -1
+	if sl.hasStart {
+		start = bound(sl.start, -1, n-1)
+	}
+	if sl.hasEnd {
+		end = bound(sl.end, -1, n-1)
+	}
+	for i := start; i > end; i += sl.step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func traversePath(data, root *node, parts []pathComponent) (*node, error) {
+	n, _, err := traverse(data, root, parts, location{known: true})
+	return n, err
+}
+
+// location is the resolved path of a node of the document, like
+// friends.2.first. The location of a node that isn't in the document, like
+// the output of a modifier, is not known.
+type location struct {
+	path  string
+	known bool
+}
+
+func (l location) child(component string) location {
+	if !l.known {
+		return l
+	}
+	return location{joinPath(l.path, component), true}
+}
+
+// join returns the location of a node found at the relative location rel
+// from a node at the location l.
+func (l location) join(rel location) location {
+	if !l.known || !rel.known || rel.path == "" {
+		return location{l.path, l.known && rel.known}
+	}
+	return l.child(rel.path)
+}
+
+// childLocation returns the location of the i-th item of a sequence, or of
+// the value of the i-th key of a mapping, that is at the location at.
+func childLocation(n *node, at location, i int, key *node) location {
+	switch {
+	case n.paths != nil:
+		return location{n.paths[i], true}
+	case key != nil:
+		return at.child(escapeComponent(key.value))
+	}
+	return at.child(strconv.Itoa(i))
+}
+
+// withPaths records the locations of the items, or values, of a collection
+// built from the matches of a path, when all of them are known.
+func withPaths(n *node, locs []location) *node {
+	paths := make([]string, len(locs))
+	for i, loc := range locs {
+		if !loc.known {
+			return n
+		}
+		paths[i] = loc.path
+	}
+	n.paths = paths
+	return n
+}
+
+// traverse is like traversePath, for data that is at the location at. It
+// also returns the location of the node that is found.
+func traverse(data, root *node, parts []pathComponent, at location) (*node, location, error) {
 	current := data
 
 	for i, part := range parts {
+		if current == nil {
+			return nil, at, &KeyError{Segment: part.segment, Reason: "the document is empty"}
+		}
+
 		if part.hasPipe {
-			// Apply pipe modifier
-			res := valueToResult(current)
-			return applyModifier(current, part.pipe, res.Raw)
+			// Apply the rest of the path to the current value
+			if part.pipe != "" && part.pipe[0] != '@' {
+				rest, err := parsePath(part.pipe)
+				if err != nil {
+					return nil, location{}, err
+				}
+				return traverse(current, root, rest, at)
+			}
+			n, err := evalPath(current, root, part.pipe, current.result().Raw)
+			return n, location{}, err
+		}
+
+		if part.isMultipath {
+			n, err := multipath(current, root, part, at)
+			if err != nil {
+				return nil, at, err
+			}
+			current, at = n, location{}
+			continue
+		}
+
+		if part.isDescent {
+			// Apply the rest of the path at any depth
+			n, err := descend(current, root, at, parts[i+1:])
+			return n, location{}, err
 		}
 
 		if part.isCount {
 			// Count operation - but check if there are more parts after this
 			if i+1 < len(parts) {
 				// There are more parts, so # means "apply to all elements"
-				switch v := current.(type) {
-				case []interface{}:
-					// Apply remaining path to all elements
-					var results []interface{}
-					for _, item := range v {
-						res := traversePath(item, parts[i+1:], origYAML)
-						if res.Exists() {
-							// Extract the actual value
-							results = append(results, res.Value())
-						}
-					}
-					return valueToResult(results)
-				case map[string]interface{}:
+				if current.kind != sequenceNode {
 					// Can't iterate over map with #
-					return Result{Type: Null}
-				}
-			} else {
-				// Just return count
-				switch v := current.(type) {
-				case []interface{}:
-					return Result{Type: Number, Num: float64(len(v)), Raw: strconv.Itoa(len(v))}
-				case map[string]interface{}:
-					return Result{Type: Number, Num: float64(len(v)), Raw: strconv.Itoa(len(v))}
-				default:
-					return Result{Type: Number, Num: 0, Raw: "0"}
+					return nil, at, &KeyError{Segment: part.segment, Reason: "value is " + current.describe() + ", not a sequence"}
 				}
+				// Apply remaining path to all elements
+				n, err := project(current, root, at, parts[i+1:])
+				return n, location{}, err
 			}
+			// Just return count
+			return newInt(current.size()), location{}, nil
 		}
 
 		if part.isQuery {
 			// Handle query
-			current = handleQuery(current, part, parts[i+1:])
-			if !part.multi {
-				// Single match, continue with remaining path
-				if i+1 < len(parts) {
-					return traversePath(current, parts[i+1:], origYAML)
-				}
-			} else {
-				// Multi match - if there are remaining parts, apply them to each match
-				if i+1 < len(parts) {
-					if matches, ok := current.([]interface{}); ok {
-						var results []interface{}
-						for _, match := range matches {
-							res := traversePath(match, parts[i+1:], origYAML)
-							if res.Exists() {
-								results = append(results, res.Value())
-							}
-						}
-						return valueToResult(results)
-					}
+			matches, loc, err := handleQuery(current, part, at)
+			if err != nil {
+				return nil, at, err
+			}
+			if part.multi && i+1 < len(parts) {
+				// Multi match - apply the remaining path to each match
+				if matches.kind == mappingNode {
+					n, err := projectValues(matches, root, loc, parts[i+1:])
+					return n, location{}, err
 				}
+				n, err := project(matches, root, loc, parts[i+1:])
+				return n, location{}, err
 			}
-			return valueToResult(current)
+			current, at = matches, loc
+			continue
 		}
 
-		switch v := current.(type) {
-		case map[string]interface{}:
+		switch current.kind {
+		case mappingNode:
 			if part.isWild {
 				// Wildcard match on object keys
-				var matches []interface{}
-				for key, val := range v {
-					if matchPattern(key, part.key) {
-						matches = append(matches, val)
+				var matches []*node
+				var locs []location
+				j := 0
+				current.pairs(func(key, value *node) bool {
+					if matchPattern(key.value, part.key) {
+						matches = append(matches, value)
+						locs = append(locs, childLocation(current, at, j, key))
 					}
-				}
+					j++
+					return true
+				})
 				if len(matches) == 1 {
-					current = matches[0]
+					current, at = matches[0], locs[0]
 				} else {
-					current = matches
+					current, at = withPaths(newSequence(matches), locs), location{}
 				}
 			} else {
-				val, ok := v[part.key]
-				if !ok {
-					return Result{Type: Null}
+				next := current.lookup(part.key)
+				if next == nil {
+					return nil, at, &KeyError{Segment: part.segment, Reason: "no such key"}
 				}
-				current = val
+				current, at = next, at.child(escapeComponent(part.key))
 			}
 
-		case []interface{}:
+		case sequenceNode:
+			items := current.items()
 			if part.isIndex {
-				if part.index < 0 || part.index >= len(v) {
-					return Result{Type: Null}
+				index := part.index
+				if index < 0 {
+					index += len(items)
+				}
+				if index < 0 || index >= len(items) {
+					return nil, at, &IndexError{Segment: part.segment, Index: part.index, Len: len(items)}
+				}
+				current, at = items[index], childLocation(current, at, index, nil)
+			} else if part.isSlice {
+				var results []*node
+				var locs []location
+				for _, j := range part.slice.indexes(len(items)) {
+					results = append(results, items[j])
+					locs = append(locs, childLocation(current, at, j, nil))
 				}
-				current = v[part.index]
+				current, at = withPaths(newSequence(results), locs), location{}
 			} else if part.key != "" {
 				// Apply to all elements in array
-				var results []interface{}
-				for _, item := range v {
-					if m, ok := item.(map[string]interface{}); ok {
-						if val, exists := m[part.key]; exists {
-							results = append(results, val)
-						}
+				var results []*node
+				var locs []location
+				for j, item := range items {
+					if val := item.lookup(part.key); val != nil {
+						results = append(results, val)
+						locs = append(locs, childLocation(current, at, j, nil).child(escapeComponent(part.key)))
 					}
 				}
 				if len(results) == 0 {
-					return Result{Type: Null}
+					return nil, at, &KeyError{Segment: part.segment, Reason: "no item of the sequence has the key"}
 				}
-				current = results
+				current, at = withPaths(newSequence(results), locs), location{}
 			}
 
 		default:
-			return Result{Type: Null}
+			return nil, at, &KeyError{Segment: part.segment, Reason: "value is " + current.describe()}
 		}
 	}
 
-	return valueToResult(current)
+	return current, at, nil
 }
 
-func handleQuery(data interface{}, part pathComponent, remainingParts []pathComponent) interface{} {
-	arr, ok := data.([]interface{})
-	if !ok {
-		return nil
-	}
-
-	query := part.query
-	matches := []interface{}{}
-
-	for _, item := range arr {
-		if evaluateQuery(item, query) {
-			matches = append(matches, item)
+// descend applies the rest of a path to a node and to every node under it,
+// and returns the matches in document order. A key is only looked up in
+// mappings, rather than in every item of a sequence, so that nothing is
+// found twice. Without a rest of the path, every node under the node is
+// returned.
+func descend(n, root *node, at location, parts []pathComponent) (*node, error) {
+	var results []*node
+	var locs []location
+	var walk func(n *node, at location, top bool) error
+	walk = func(n *node, at location, top bool) error {
+		if n.kind == scalarNode {
+			if len(parts) == 0 && !top {
+				results = append(results, n)
+				locs = append(locs, at)
+			}
+			return nil
 		}
-	}
-
-	if part.multi {
-		// Return all matches
-		return matches
-	}
-
-	// Return first match
-	if len(matches) > 0 {
-		return matches[0]
-	}
-	return nil
-}
-
-func evaluateQuery(item interface{}, query string) bool {
-	// Parse query: key op value
-	// Supported operators: ==, !=, <, <=, >, >=, %, !%
-
-	var key, op, value string
-
-	// Find operator
-	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">", "!%", "%"} {
-		if idx := strings.Index(query, operator); idx != -1 {
-			key = strings.TrimSpace(query[:idx])
-			op = operator
-			value = strings.TrimSpace(query[idx+len(operator):])
-			break
+		switch {
+		case len(parts) == 0:
+			if !top {
+				results = append(results, n)
+				locs = append(locs, at)
+			}
+		case n.kind == mappingNode || !parts[0].isKey():
+			res, loc, err := traverse(n, root, parts, at)
+			if err != nil && !isMissing(err) {
+				return err
+			}
+			if err == nil && res != nil {
+				results = append(results, res)
+				locs = append(locs, loc)
+			}
 		}
-	}
-
-	if op == "" {
-		return false
-	}
-
-	// Remove quotes from value
-	value = strings.Trim(value, "\"'")
-
-	// Get the value from item
-	var itemValue interface{}
-	if key == "" {
-		itemValue = item
-	} else {
-		itemValue = getValueByKey(item, key)
-	}
-
-	return compareValues(itemValue, op, value)
-}
-
-func getValueByKey(data interface{}, key string) interface{} {
-	m, ok := data.(map[string]interface{})
-	if !ok {
-		return nil
-	}
-
-	// Handle nested keys
-	if strings.Contains(key, ".") {
-		parts := strings.Split(key, ".")
-		current := data
-		for _, part := range parts {
-			if m, ok := current.(map[string]interface{}); ok {
-				current = m[part]
-			} else {
-				return nil
+		var err error
+		i := 0
+		visit := func(key, value *node) bool {
+			err = walk(value, childLocation(n, at, i, key), false)
+			i++
+			return err == nil
+		}
+		if n.kind == mappingNode {
+			n.pairs(visit)
+		} else {
+			for _, item := range n.items() {
+				if !visit(nil, item) {
+					break
+				}
 			}
 		}
-		return current
+		return err
 	}
-
-	return m[key]
+	if err := walk(n, at, true); err != nil {
+		return nil, err
+	}
+	return withPaths(newSequence(results), locs), nil
 }
 
-func compareValues(itemValue interface{}, op string, value string) bool {
-	switch op {
-	case "==":
-		return fmt.Sprint(itemValue) == value
-	case "!=":
-		return fmt.Sprint(itemValue) != value
-	case "%":
-		// Pattern match
-		return matchPattern(fmt.Sprint(itemValue), value)
-	case "!%":
-		return !matchPattern(fmt.Sprint(itemValue), value)
-	case "<", "<=", ">", ">=":
-		return compareNumeric(itemValue, op, value)
-	}
-	return false
+// isKey returns true for a component that is only a key of a mapping.
+func (part pathComponent) isKey() bool {
+	return !part.isIndex && !part.isSlice && !part.isQuery && !part.isCount &&
+		!part.hasPipe && !part.isDescent && !part.isMultipath
 }
 
-func compareNumeric(itemValue interface{}, op string, value string) bool {
-	var itemNum float64
-	switch v := itemValue.(type) {
-	case float64:
-		itemNum = v
-	case int:
-		itemNum = float64(v)
-	case int64:
-		itemNum = float64(v)
-	case string:
-		itemNum, _ = strconv.ParseFloat(v, 64)
-	default:
-		return false
+// project applies the rest of a path to every item of a sequence that is at
+// the location at, leaving out the items it's not found in.
+func project(seq, root *node, at location, parts []pathComponent) (*node, error) {
+	var results []*node
+	var locs []location
+	for i, item := range seq.items() {
+		res, loc, err := traverse(item, root, parts, childLocation(seq, at, i, nil))
+		if err != nil {
+			if isMissing(err) {
+				continue
+			}
+			return nil, err
+		}
+		if res != nil {
+			results = append(results, res)
+			locs = append(locs, loc)
+		}
 	}
-
-	valueNum, err := strconv.ParseFloat(value, 64)
+	return withPaths(newSequence(results), locs), nil
+}
+
+// projectValues applies a path to the values of a mapping that is at the
+// location at, keeping the keys of the values that the path is found in.
+func projectValues(m, root *node, at location, parts []pathComponent) (*node, error) {
+	var results []*node
+	var locs []location
+	var err error
+	i := 0
+	m.pairs(func(key, value *node) bool {
+		var res *node
+		var loc location
+		res, loc, err = traverse(value, root, parts, childLocation(m, at, i, key))
+		i++
+		if err != nil {
+			if !isMissing(err) {
+				return false
+			}
+			err = nil
+		} else if res != nil {
+			results = append(results, key, res)
+			locs = append(locs, loc)
+		}
+		return true
+	})
 	if err != nil {
-		return false
-	}
-
-	switch op {
-	case "<":
-		return itemNum < valueNum
-	case "<=":
-		return itemNum <= valueNum
-	case ">":
-		return itemNum > valueNum
-	case ">=":
-		return itemNum >= valueNum
+		return nil, err
+	}
+	return withPaths(newMapping(results), locs), nil
+}
+
+// handleQuery returns the first item, or value of a mapping, that matches
+// a query, or all the matches for a #(...)# query. The location of the
+// first match is returned too.
+func handleQuery(data *node, part pathComponent, at location) (*node, location, error) {
+	matches := []*node{}
+	var locs []location
+	switch data.kind {
+	case sequenceNode:
+		for i, item := range data.items() {
+			if part.expr.match(item, queryKey{index: i}) {
+				loc := childLocation(data, at, i, nil)
+				if !part.multi {
+					// Return first match
+					return item, loc, nil
+				}
+				matches = append(matches, item)
+				locs = append(locs, loc)
+			}
+		}
+		if part.multi {
+			// Return all matches
+			return withPaths(newSequence(matches), locs), location{}, nil
+		}
+	case mappingNode:
+		// the values of a mapping are queried, and all the matches are
+		// returned with their keys
+		var first *node
+		var firstLoc location
+		i := 0
+		data.pairs(func(key, value *node) bool {
+			i++
+			if part.expr.match(value, queryKey{key: key}) {
+				loc := childLocation(data, at, i-1, key)
+				if !part.multi {
+					first, firstLoc = value, loc
+					return false
+				}
+				matches = append(matches, key, value)
+				locs = append(locs, loc)
+			}
+			return true
+		})
+		if first != nil {
+			return first, firstLoc, nil
+		}
+		if part.multi {
+			return withPaths(newMapping(matches), locs), location{}, nil
+		}
+	default:
+		return nil, at, &KeyError{Segment: part.segment, Reason: "value is " + data.describe() + ", not a sequence or a mapping"}
 	}
-	return false
+	return nil, at, &KeyError{Segment: part.segment, Reason: "no item matches the query"}
 }
 
 func matchPattern(str, pattern string) bool {
@@ -1107,98 +1686,250 @@ func deepMatch(str, pattern string) bool {
 
 // Modifiers
 
-var modifiers = map[string]func(yaml, arg string) string{
-	"reverse": modReverse,
-	"ugly":    modUgly,
-	"pretty":  modPretty,
-	"this":    modThis,
-	"valid":   modValid,
-	"flatten": modFlatten,
-	"join":    modJoin,
-	"keys":    modKeys,
-	"values":  modValues,
+var modifiers map[string]func(yaml, arg string) string
+
+// nodeModifiers are built-in modifiers that build their output out of the
+// nodes of their input, so that the values they return keep their
+// positions in the document. A custom modifier with the same name replaces
+// them.
+var nodeModifiers map[string]func(data *node, arg string) *node
+
+func init() {
+	// modifiers is set in init, because some modifiers evaluate paths
+	modifiers = map[string]func(yaml, arg string) string{
+		"reverse":    modReverse,
+		"ugly":       modUgly,
+		"pretty":     modPretty,
+		"this":       modThis,
+		"valid":      modValid,
+		"flatten":    modFlatten,
+		"join":       modJoin,
+		"keys":       modKeys,
+		"values":     modValues,
+		"match":      modMatch,
+		"sort":       modSort,
+		"count":      modCount,
+		"sum":        modSum,
+		"avg":        modAvg,
+		"min":        modMin,
+		"max":        modMax,
+		"groupby":    modGroupBy,
+		"countby":    modCountBy,
+		"uniq":       modUniq,
+		"union":      modUnion,
+		"intersect":  modIntersect,
+		"difference": modDifference,
+		"merge":      modMerge,
+	}
+	checkedModifiers = map[string]func(arg string) error{
+		"merge": checkMergeArgument,
+	}
+	nodeModifiers = map[string]func(data *node, arg string) *node{
+		"this":    func(data *node, arg string) *node { return data },
+		"groupby": groupBy,
+	}
+}
+
+// checkedModifiers are the built-in modifiers whose argument is checked
+// before they are applied, so that an invalid one is reported as a
+// ModifierError.
+var checkedModifiers map[string]func(arg string) error
+
+// setModifiers are the modifiers whose argument is a path, which is passed
+// to them as the yaml of its value. See documentArgument.
+var setModifiers = map[string]bool{
+	"union":      true,
+	"intersect":  true,
+	"difference": true,
 }
 
 // AddModifier adds a custom modifier
 func AddModifier(name string, fn func(yaml, arg string) string) {
 	modifiers[name] = fn
+	delete(nodeModifiers, name)
+	delete(setModifiers, name)
+	delete(checkedModifiers, name)
 }
 
-func applyModifier(data interface{}, path string, yamlStr string) Result {
-	if !strings.HasPrefix(path, "@") {
-		return valueToResult(data)
+func applyModifier(data, root *node, path string, yamlStr string) (*node, error) {
+	// Parse modifier name, argument and the rest of the path
+	modName, modArg, rest := parseModifier(path[1:])
+
+	fn, ok := modifiers[modName]
+	if !ok {
+		return nil, &ModifierError{Segment: "@" + modName, Name: modName}
 	}
 
-	// Parse modifier name and argument
-	var modName, modArg string
-	if idx := strings.Index(path, ":"); idx != -1 {
-		modName = path[1:idx]
-		modArg = path[idx+1:]
+	if setModifiers[modName] {
+		arg, err := documentArgument(root, modArg)
+		if err != nil {
+			return nil, &ModifierError{Segment: path[:len(path)-len(rest)], Name: modName, Reason: err.Error()}
+		}
+		modArg = arg
+	}
+	if check, ok := checkedModifiers[modName]; ok {
+		if err := check(modArg); err != nil {
+			reason := strings.TrimPrefix(err.Error(), "gyaml: ")
+			return nil, &ModifierError{Segment: path[:len(path)-len(rest)], Name: modName, Reason: reason}
+		}
+	}
+	var newData *node
+	var result string
+	if nodeFn, ok := nodeModifiers[modName]; ok && data != nil {
+		newData = nodeFn(data, modArg)
+		result = newData.marshal()
 	} else {
-		modName = path[1:]
+		result = fn(yamlStr, modArg)
+		newData, _ = parseValue(result)
+	}
+	if rest == "" {
+		return newData, nil
 	}
 
-	// Check for pipe after modifier
-	if idx := strings.Index(modName, "|"); idx != -1 {
-		remaining := modName[idx:]
-		modName = modName[:idx]
-		// Apply modifier then continue with pipe
-		if fn, ok := modifiers[modName]; ok {
-			result := fn(yamlStr, modArg)
-			return applyModifier(data, remaining[1:], result)
+	// Continue with the path after the '|' or '.', whose values are not in
+	// the document, so that their locations are not known
+	rest = rest[1:]
+	if rest == "" || rest[0] == '@' {
+		return evalPath(newData, root, rest, result)
+	}
+	parts, err := parsePath(rest)
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := traverse(newData, root, parts, location{})
+	return n, err
+}
+
+// documentArgument returns the yaml of the value at the path argument of a
+// set modifier. The path is looked up in root, the value that the whole
+// path is searched in: the document for Get and Document.Get, or the
+// result for Result.Get. A flow sequence is returned as it is.
+func documentArgument(root *node, arg string) (string, error) {
+	if strings.HasPrefix(arg, "[") {
+		return arg, nil
+	}
+	path := unquoteArgument(arg)
+	if root == nil || path == "" {
+		return "", errors.New("no value at the argument path " + strconv.Quote(path))
+	}
+	n, err := evalPath(root, root, path, root.result().Raw)
+	if err != nil || n == nil {
+		return "", errors.New("no value at the argument path " + strconv.Quote(path))
+	}
+	return n.marshal(), nil
+}
+
+// parseModifier splits a modifier, without its '@', into its name, its
+// argument and the rest of the path. An argument that starts with '{', '['
+// or '"' ends with its closing character, any other ends at the next '|'.
+func parseModifier(s string) (name, arg, rest string) {
+	i := strings.IndexAny(s, ":|.")
+	if i == -1 {
+		return s, "", ""
+	}
+	name = s[:i]
+	if s[i] != ':' {
+		return name, "", s[i:]
+	}
+	s = s[i+1:]
+	end := len(s)
+	if len(s) > 0 && (s[0] == '{' || s[0] == '[' || s[0] == '"') {
+		end = argumentEnd(s)
+	} else if j := strings.IndexByte(s, '|'); j != -1 {
+		end = j
+	}
+	return name, s[:end], s[end:]
+}
+
+// argumentEnd returns the end of a modifier argument that is an object, an
+// array or a quoted string.
+func argumentEnd(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		}
+		if depth == 0 {
+			if i >= len(s) {
+				return len(s)
+			}
+			return i + 1
 		}
 	}
+	return len(s)
+}
 
-	if fn, ok := modifiers[modName]; ok {
-		result := fn(yamlStr, modArg)
-		var newData interface{}
-		yamlv3.Unmarshal([]byte(result), &newData)
-		return valueToResult(newData)
+// unquoteArgument returns the string of a quoted modifier argument, which
+// is quoted to hold a '|' or a '.', or the argument as it is.
+func unquoteArgument(arg string) string {
+	if len(arg) > 1 && arg[0] == '"' {
+		if s, err := strconv.Unquote(arg); err == nil {
+			return s
+		}
+		return arg[1 : len(arg)-1]
 	}
+	return arg
+}
 
-	return valueToResult(data)
+// modifierInput parses the yaml passed to a built-in modifier, keeping the
+// order of mapping keys. Nil is returned if the yaml is not valid or empty.
+func modifierInput(yamlStr string) *node {
+	n, err := parseValue(yamlStr)
+	if err != nil {
+		return nil
+	}
+	return n
 }
 
 func modReverse(yamlStr, arg string) string {
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil {
 		return yamlStr
 	}
 
-	switch v := data.(type) {
-	case []interface{}:
-		reversed := make([]interface{}, len(v))
-		for i, item := range v {
-			reversed[len(v)-1-i] = item
+	switch n.kind {
+	case sequenceNode:
+		items := n.items()
+		reversed := make([]*node, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
 		}
-		result, _ := yamlv3.Marshal(reversed)
-		return string(result)
-	case map[string]interface{}:
-		// Reverse doesn't really apply to maps in the same way
-		result, _ := yamlv3.Marshal(v)
-		return string(result)
+		return newSequence(reversed).marshal()
+	case mappingNode:
+		reversed := make([]*node, 0, len(n.content))
+		for i := len(n.content) - 2; i >= 0; i -= 2 {
+			reversed = append(reversed, n.content[i], n.content[i+1])
+		}
+		return newMapping(reversed).marshal()
 	}
 	return yamlStr
 }
 
 func modUgly(yamlStr, arg string) string {
 	// Remove unnecessary whitespace
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil {
 		return yamlStr
 	}
-	result, _ := yamlv3.Marshal(data)
-	return strings.TrimSpace(string(result))
+	return strings.TrimSpace(n.marshal())
 }
 
 func modPretty(yamlStr, arg string) string {
 	// YAML is already pretty by default
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil {
 		return yamlStr
 	}
-	result, _ := yamlv3.Marshal(data)
-	return string(result)
+	return n.marshal()
 }
 
 func modThis(yamlStr, arg string) string {
@@ -1213,24 +1944,18 @@ func modValid(yamlStr, arg string) string {
 }
 
 func modFlatten(yamlStr, arg string) string {
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
 		return yamlStr
 	}
-
-	if arr, ok := data.([]interface{}); ok {
-		flattened := flattenArray(arr)
-		result, _ := yamlv3.Marshal(flattened)
-		return string(result)
-	}
-	return yamlStr
+	return newSequence(flattenItems(n.items())).marshal()
 }
 
-func flattenArray(arr []interface{}) []interface{} {
-	var result []interface{}
-	for _, item := range arr {
-		if subArr, ok := item.([]interface{}); ok {
-			result = append(result, flattenArray(subArr)...)
+func flattenItems(items []*node) []*node {
+	var result []*node
+	for _, item := range items {
+		if item.kind == sequenceNode {
+			result = append(result, flattenItems(item.items())...)
 		} else {
 			result = append(result, item)
 		}
@@ -1239,58 +1964,87 @@ func flattenArray(arr []interface{}) []interface{} {
 }
 
 func modJoin(yamlStr, arg string) string {
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
 		return yamlStr
 	}
 
-	if arr, ok := data.([]interface{}); ok {
-		joined := make(map[string]interface{})
-		for _, item := range arr {
-			if m, ok := item.(map[string]interface{}); ok {
-				for k, v := range m {
-					joined[k] = v
-				}
+	// a key keeps the place where it first appears, and the value of its
+	// last appearance
+	var joined []*node
+	index := make(map[string]int)
+	for _, item := range n.items() {
+		item.pairs(func(key, value *node) bool {
+			if i, ok := index[key.value]; ok {
+				joined[i+1] = value
+			} else {
+				index[key.value] = len(joined)
+				joined = append(joined, key, value)
 			}
-		}
-		result, _ := yamlv3.Marshal(joined)
-		return string(result)
+			return true
+		})
 	}
-	return yamlStr
+	return newMapping(joined).marshal()
 }
 
 func modKeys(yamlStr, arg string) string {
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != mappingNode {
 		return yamlStr
 	}
 
-	if m, ok := data.(map[string]interface{}); ok {
-		keys := make([]interface{}, 0, len(m))
-		for k := range m {
-			keys = append(keys, k)
-		}
-		result, _ := yamlv3.Marshal(keys)
-		return string(result)
-	}
-	return yamlStr
+	keys := make([]*node, 0, n.size())
+	n.pairs(func(key, value *node) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return newSequence(keys).marshal()
 }
 
 func modValues(yamlStr, arg string) string {
-	var data interface{}
-	if err := yamlv3.Unmarshal([]byte(yamlStr), &data); err != nil {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != mappingNode {
+		return yamlStr
+	}
+
+	values := make([]*node, 0, n.size())
+	n.pairs(func(key, value *node) bool {
+		values = append(values, value)
+		return true
+	})
+	return newSequence(values).marshal()
+}
+
+func modMatch(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
 		return yamlStr
 	}
 
-	if m, ok := data.(map[string]interface{}); ok {
-		values := make([]interface{}, 0, len(m))
-		for _, v := range m {
-			values = append(values, v)
+	// the pattern may be quoted, to have a | in it
+	re, err := compileRegexp(unquoteArgument(arg))
+	if err != nil {
+		return ""
+	}
+	var matches []*node
+	for _, item := range n.items() {
+		if item.kind == scalarNode && re.MatchString(item.value) {
+			matches = append(matches, item)
 		}
-		result, _ := yamlv3.Marshal(values)
-		return string(result)
 	}
-	return yamlStr
+	return newSequence(matches).marshal()
+}
+
+// firstDocument returns the span of the first document of a YAML stream.
+func firstDocument(yaml string) document {
+	if !strings.Contains(yaml, "---") && !strings.Contains(yaml, "...") {
+		return document{0, len(yaml)}
+	}
+	docs := splitDocuments(yaml)
+	if len(docs) == 0 {
+		return document{}
+	}
+	return docs[0]
 }
 
 // ForEachLine iterates through lines of YAML
@@ -1,7 +1,13 @@
 package gyaml
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 const testYAML = `
@@ -136,6 +142,201 @@ func TestQueryComparison(t *testing.T) {
 	}
 }
 
+func TestQueryExpressions(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`friends.#(age>40 && last=="Murphy")#.first`, "Dale,Jane"},
+		{`friends.#(age>60 || first=="Dale")#.first`, "Dale,Roger"},
+		{`friends.#((age>60 || age<45) && nets.#>1)#.first`, "Dale,Roger"},
+		{`friends.#(!(last=="Murphy"))#.first`, "Roger"},
+		{`friends.#(!last%"M*")#.first`, "Roger"},
+		{`friends.#(first=="Roger" || first=="Dale" && age>50)#.first`, "Roger"},
+		{`friends.#((first=="Roger" || first=="Dale") && age<50)#.first`, "Dale"},
+		{`friends.#(nets.#(=="ig"))#.first`, "Dale,Jane"},
+		{`friends.#(nets.#(=="ig") && !nets.#(=="fb"))#.first`, "Jane"},
+		{`friends.#(nets.#>2)#.first`, "Dale"},
+		{`friends.#(middle)#.first`, ""},
+		{`friends.#(first=="Jane (J)" || last == "Craig")#.first`, "Roger"},
+		{`children.#(!="Alex")#`, "Sara,Jack"},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, res := range Get(testYAML, tt.path).Array() {
+			got = append(got, res.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	errs := []struct {
+		path   string
+		reason string
+	}{
+		{`friends.#(age>40 &&)`, "unexpected end of query"},
+		{`friends.#(age>40 || )#`, "unexpected end of query"},
+		{`friends.#((age>40)`, "missing closing parenthesis"},
+		{`friends.#(age 40)`, `unexpected "40"`},
+		{`friends.#(&& age>40)`, `unexpected "&& age>40"`},
+		{`friends.#(first=="Dale)`, "missing closing parenthesis"},
+		{`friends.#(nets.#(==))`, `no value after "=="`},
+	}
+	for _, tt := range errs {
+		_, err := Compile(tt.path)
+		queryErr, ok := err.(*QueryError)
+		if !ok || queryErr.Reason != tt.reason {
+			t.Errorf("Compile(%q) error = %v, want reason %q", tt.path, err, tt.reason)
+		}
+	}
+}
+
+func TestQueryLiterals(t *testing.T) {
+	yaml := `
+items:
+  - name: a
+    count: 5
+    active: true
+    tags: [x, y]
+  - name: b
+    count: "5"
+    active: "true"
+    x: null
+  - name: c
+    count: 5.0
+    active: false
+    x: ~
+    tags: [y]
+  - name: d
+    count: 9223372036854775807
+    active: yes
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`items.#(count==5)#.name`, "a,c"},
+		{`items.#(count=="5")#.name`, "b"},
+		{`items.#(count!=5)#.name`, "b,d"},
+		{`items.#(count==9223372036854775807)#.name`, "d"},
+		{`items.#(count<9223372036854775807)#.name`, "a,c"},
+		{`items.#(active==true)#.name`, "a"},
+		{`items.#(active=="true")#.name`, "b"},
+		{`items.#(active==false)#.name`, "c"},
+		{`items.#(x==null)#.name`, "b,c"},
+		{`items.#(x==~)#.name`, "b,c"},
+		{`items.#(tags==[x, y])#.name`, "a"},
+		{`items.#(tags=={a: 1})#.name`, ""},
+		// the type order is null < false < numbers < strings < true
+		{`items.#(count>5)#.name`, "b,d"},
+		{`items.#(active>false)#.name`, "a,b,d"},
+		{`items.#(active<"t")#.name`, "c"},
+		{`items.#(x<false)#.name`, "b,c"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, res := range Get(yaml, tt.path).Array() {
+			got = append(got, res.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{`items.#(tags==[x, y)`, `items.#(tags=={a: [})`} {
+		if _, err := Compile(path); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", path)
+		}
+	}
+}
+
+func TestQueryRegexp(t *testing.T) {
+	yaml := `
+images:
+  - name: a
+    tag: v1.2
+  - name: b
+    tag: v1.2-rc
+  - name: c
+    tag: latest
+tags: [v1.0, v2, latest, v10.3]
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`images.#(tag=~"^v\\d+\\.\\d+$")#.name`, "a"},
+		{`images.#(tag=~^v\d+\.\d+$)#.name`, "a"},
+		{`images.#(tag!~"^v")#.name`, "c"},
+		{`images.#(tag=~"^v" && tag!~"rc")#.name`, "a"},
+		{`tags.#(=~"^v")#`, "v1.0,v2,v10.3"},
+		{`tags|@match:^v\d+\.\d+$`, "v1.0,v10.3"},
+		{`tags|@match:"^(v2|latest)$"`, "v2,latest"},
+		{`tags|@match:^x`, ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, res := range Get(yaml, tt.path).Array() {
+			got = append(got, res.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := Compile(`images.#(tag=~"(")`); err == nil {
+		t.Error("Compile accepted an invalid regexp")
+	}
+}
+
+func TestQueryMapping(t *testing.T) {
+	yaml := `
+services:
+  api:
+    port: 8080
+  db:
+    port: 5432
+  web:
+    port: 9000
+    tls: true
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`services.#(port>8000)#.port`, "api=8080,web=9000"},
+		{`services.#(port>8000)#.tls`, "web=true"},
+		{`services.#(port>8000).port`, "8080"},
+		{`services.#(@key=="db").port`, "5432"},
+		{`services.#(@key!="db" && !tls)#.port`, "api=8080"},
+		{`services.#(port>9999)#`, ""},
+		{`services.#(port>9999)`, ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		Get(yaml, tt.path).ForEach(func(key, value Result) bool {
+			if key.Exists() {
+				got = append(got, key.String()+"="+value.String())
+			} else {
+				got = append(got, value.String())
+			}
+			return true
+		})
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	if got := Get(testYAML, `friends.#(@key==1).first`).String(); got != "Roger" {
+		t.Errorf("friends.#(@key==1).first = %q, want Roger", got)
+	}
+	if _, err := GetE(yaml, `services.api.port.#(==1)`); err == nil {
+		t.Error("a query of a scalar succeeded")
+	}
+}
+
 func TestTypes(t *testing.T) {
 	// String
 	result := Get(testYAML, "name.first")
@@ -170,6 +371,47 @@ func TestExists(t *testing.T) {
 	}
 }
 
+func TestNull(t *testing.T) {
+	yaml := "word: null\ntilde: ~\nempty:\nquoted: \"null\"\nlist: [1, null]\n"
+	doc, err := ParseDocument(yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		null   bool
+		exists bool
+	}{
+		{"word", true, true},
+		{"tilde", true, true},
+		{"empty", true, true},
+		{"list.1", true, true},
+		{"list|@reverse|0", true, true},
+		{"quoted", false, true},
+		{"missing", false, false},
+		{"word.child", false, false},
+	}
+	for _, tt := range tests {
+		for _, res := range []Result{Get(yaml, tt.path), doc.Get(tt.path)} {
+			if res.IsNull() != tt.null || res.Exists() != tt.exists {
+				t.Errorf("Get(%q): IsNull() = %v, Exists() = %v, want %v, %v",
+					tt.path, res.IsNull(), res.Exists(), tt.null, tt.exists)
+			}
+		}
+	}
+
+	var nulls []string
+	Parse(yaml).ForEach(func(key, value Result) bool {
+		if value.IsNull() {
+			nulls = append(nulls, key.String())
+		}
+		return true
+	})
+	if strings.Join(nulls, ",") != "word,tilde,empty" {
+		t.Errorf("ForEach nulls = %v, want [word tilde empty]", nulls)
+	}
+}
+
 func TestIsArray(t *testing.T) {
 	if !Get(testYAML, "children").IsArray() {
 		t.Error("children should be an array")
@@ -287,6 +529,40 @@ func TestGetMany(t *testing.T) {
 	}
 }
 
+func TestGetManyYAML(t *testing.T) {
+	yaml := `
+name:
+  first: Tom
+  last: Anderson
+quote: "a: b, [c]"
+children: [Sara, Alex]
+`
+	result := GetMany(yaml, "name", "quote", "missing", "children", "name.first")
+	want := "- first: Tom\n  last: Anderson\n- 'a: b, [c]'\n- null\n- - Sara\n  - Alex\n- Tom\n"
+	if result.Raw != want {
+		t.Errorf("GetMany = %q, want %q", result.Raw, want)
+	}
+	var decoded []interface{}
+	if err := yamlv3.Unmarshal([]byte(result.Raw), &decoded); err != nil || len(decoded) != 5 {
+		t.Errorf("GetMany is not a valid sequence of 5 values: %v", err)
+	}
+	if got := GetMany("a: [", "a").Raw; got != "[]\n" {
+		t.Errorf("GetMany of invalid yaml = %q, want []", got)
+	}
+
+	results := GetManyResults(yaml, "name.last", "missing", "children.#", "quote")
+	if len(results) != 4 || results[0].String() != "Anderson" || results[1].Exists() ||
+		results[2].Int() != 2 || results[3].String() != "a: b, [c]" {
+		t.Errorf("GetManyResults = %v", results)
+	}
+	if results[0].Path() != "name.last" || results[0].Line != 4 {
+		t.Errorf("GetManyResults[0] at %q, line %d", results[0].Path(), results[0].Line)
+	}
+	if results := GetManyResults("a: [", "a", "b"); len(results) != 2 || results[0].Exists() {
+		t.Errorf("GetManyResults of invalid yaml = %v", results)
+	}
+}
+
 func TestModifierReverse(t *testing.T) {
 	result := Get(testYAML, "children|@reverse")
 	arr := result.Array()
@@ -313,14 +589,9 @@ func TestModifierKeys(t *testing.T) {
 		return
 	}
 
-	// Keys should be "first" and "last" (order may vary)
-	keys := make(map[string]bool)
-	for _, item := range arr {
-		keys[item.String()] = true
-	}
-
-	if !keys["first"] || !keys["last"] {
-		t.Error("@keys should contain 'first' and 'last'")
+	// Keys follow the order of the document
+	if arr[0].String() != "first" || arr[1].String() != "last" {
+		t.Errorf("@keys = %v, want [first last]", arr)
 	}
 }
 
@@ -333,14 +604,9 @@ func TestModifierValues(t *testing.T) {
 		return
 	}
 
-	// Values should be "Tom" and "Anderson" (order may vary)
-	values := make(map[string]bool)
-	for _, item := range arr {
-		values[item.String()] = true
-	}
-
-	if !values["Tom"] || !values["Anderson"] {
-		t.Error("@values should contain 'Tom' and 'Anderson'")
+	// Values follow the order of the document
+	if arr[0].String() != "Tom" || arr[1].String() != "Anderson" {
+		t.Errorf("@values = %v, want [Tom Anderson]", arr)
 	}
 }
 
@@ -487,6 +753,23 @@ func TestAddModifier(t *testing.T) {
 	}
 }
 
+func TestAddModifierOverride(t *testing.T) {
+	union := modifiers["union"]
+	defer func() {
+		modifiers["union"] = union
+		setModifiers["union"] = true
+	}()
+
+	// the argument of a custom modifier is passed as it is written
+	AddModifier("union", func(yaml, arg string) string {
+		return strconv.Quote(arg)
+	})
+	res, err := GetE(testYAML, "children|@union:nothing.here")
+	if err != nil || res.String() != "nothing.here" {
+		t.Errorf("custom @union = %q, %v, want nothing.here", res.String(), err)
+	}
+}
+
 // Benchmark tests
 
 func BenchmarkGet(b *testing.B) {
@@ -513,6 +796,20 @@ func BenchmarkQuery(b *testing.B) {
 	}
 }
 
+func BenchmarkCompiledQuery(b *testing.B) {
+	p := MustCompile(`friends.#(last=="Murphy").first`)
+	for i := 0; i < b.N; i++ {
+		p.Get(testYAML)
+	}
+}
+
+func BenchmarkDocumentQuery(b *testing.B) {
+	doc, _ := ParseDocument(testYAML)
+	for i := 0; i < b.N; i++ {
+		doc.Get(`friends.#(last=="Murphy").first`)
+	}
+}
+
 func BenchmarkParse(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		Parse(testYAML)
@@ -524,3 +821,1204 @@ func BenchmarkValid(b *testing.B) {
 		Valid(testYAML)
 	}
 }
+
+const testStreamYAML = `# manifests
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+kind: Deployment
+metadata:
+  name: worker
+`
+
+func TestGetDocuments(t *testing.T) {
+	docs := GetDocuments(testStreamYAML)
+	if len(docs) != 3 {
+		t.Fatalf("len(GetDocuments) = %d, want 3", len(docs))
+	}
+	expected := []string{"Service", "Deployment", "Deployment"}
+	for i, doc := range docs {
+		if doc.Get("kind").String() != expected[i] {
+			t.Errorf("docs[%d].kind = %q, want %q", i, doc.Get("kind").String(), expected[i])
+		}
+		if testStreamYAML[doc.Index:doc.Index+len(doc.Raw)] != doc.Raw {
+			t.Errorf("docs[%d].Index does not point at its raw text", i)
+		}
+	}
+
+	stream := "---\na: 1\n---\n---\nb: 2\n"
+	docs = GetDocuments(stream)
+	if len(docs) != 3 || !docs[1].IsNull() || docs[1].Raw != "" || docs[2].Get("b").Int() != 2 {
+		t.Errorf("GetDocuments with an empty document = %v", docs)
+	}
+	// the empty document is the same through the .. prefix
+	if empty := Get(stream, "..1"); empty.Type != docs[1].Type || empty.Raw != docs[1].Raw ||
+		empty.Exists() != docs[1].Exists() || empty.Index != docs[1].Index || empty.Line != docs[1].Line {
+		t.Errorf("Get(..1) = %+v, want %+v", empty, docs[1])
+	}
+}
+
+func TestForEachDocument(t *testing.T) {
+	var names []string
+	ForEachDocument(testStreamYAML, func(doc Result) bool {
+		names = append(names, doc.Get("metadata.name").String())
+		return len(names) < 2
+	})
+	if len(names) != 2 || names[0] != "web" || names[1] != "web" {
+		t.Errorf("ForEachDocument names = %v", names)
+	}
+}
+
+func TestDocumentStreamPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"..#", "3"},
+		{"..1.kind", "Deployment"},
+		{"..2.metadata.name", "worker"},
+		{`..#(kind=="Deployment").metadata.name`, "web"},
+	}
+	for _, tt := range tests {
+		result := Get(testStreamYAML, tt.path)
+		if result.String() != tt.expected {
+			t.Errorf("Get(%q) = %q, want %q", tt.path, result.String(), tt.expected)
+		}
+	}
+
+	arr := Get(testStreamYAML, `..#(kind=="Deployment")#.metadata.name`).Array()
+	if len(arr) != 2 || arr[0].String() != "web" || arr[1].String() != "worker" {
+		t.Errorf("deployment names = %v", arr)
+	}
+
+	// a stream is not split into lines, and stops at an invalid document
+	lines := "{\"name\": \"Gilbert\"}\n{\"name\": \"Alexa\"}\n"
+	if _, err := GetE(lines, "..#"); err == nil {
+		t.Error("GetE(lines, ..#) didn't return an error")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("GetE(lines, ..#) error = %T, want *SyntaxError", err)
+	}
+	invalid := "a: 1\n---\nb: [\n---\nc: 3\n"
+	if _, err := GetE(invalid, "..#"); err == nil {
+		t.Error("GetE(invalid, ..#) didn't return an error")
+	}
+	if n := Get(invalid, "..#").Int(); n != 1 {
+		t.Errorf("Get(invalid, ..#) = %d, want the 1 document before the invalid one", n)
+	}
+	if Get(invalid, "..2.c").Exists() {
+		t.Error("Get(invalid, ..2.c) found a document after an invalid one")
+	}
+}
+
+func TestGetFirstDocument(t *testing.T) {
+	if Get(testStreamYAML, "kind").String() != "Service" {
+		t.Errorf("kind = %q, want %q", Get(testStreamYAML, "kind").String(), "Service")
+	}
+	// keys of later documents are not visible without the .. prefix
+	if Get("a: 1\n---\nb: 2\n", "b").Exists() {
+		t.Error("b should only exist in the second document")
+	}
+}
+
+const testAnchorYAML = `
+base: &base
+  host: localhost
+  port: 5432
+dev:
+  <<: *base
+  port: 6543
+prod:
+  <<: [*base]
+  host: db.example.com
+backup: *base
+ports:
+  - &main 80
+  - *main
+`
+
+func TestAliases(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		alias    string
+	}{
+		{"base.host", "localhost", ""},
+		{"dev.host", "localhost", "base"},
+		{"dev.port", "6543", ""},
+		{"prod.host", "db.example.com", ""},
+		{"prod.port", "5432", "base"},
+		{"backup.port", "5432", "base"},
+		{"backup.host", "localhost", "base"},
+		// simple paths like this one used to be answered by the fast path
+		// with the alias text itself
+		{"ports.1", "80", "main"},
+	}
+	for _, tt := range tests {
+		result := Get(testAnchorYAML, tt.path)
+		if result.String() != tt.expected {
+			t.Errorf("Get(%q) = %q, want %q", tt.path, result.String(), tt.expected)
+		}
+		if result.Alias != tt.alias {
+			t.Errorf("Get(%q).Alias = %q, want %q", tt.path, result.Alias, tt.alias)
+		}
+	}
+
+	result := Get(testAnchorYAML, "backup")
+	if !result.IsObject() || result.Get("port").Int() != 5432 {
+		t.Errorf("backup = %q, want the base mapping", result.Raw)
+	}
+}
+
+// laughs returns a document whose last key holds levels nested sequences
+// of ten aliases each.
+func laughs(levels int) string {
+	var b strings.Builder
+	b.WriteString("a: &a [x, x, x, x, x, x, x, x, x, x]\n")
+	for i := 1; i < levels; i++ {
+		name, prev := string(rune('a'+i)), string(rune('a'+i-1))
+		b.WriteString(name + ": &" + name + " [" + strings.Repeat("*"+prev+", ", 9) + "*" + prev + "]\n")
+	}
+	return b.String()
+}
+
+func TestExcessiveAliasing(t *testing.T) {
+	y := laughs(9)
+	last := string(rune('a' + 8))
+	if res := Get(y, last); res.Exists() {
+		t.Errorf("Get(%s) of a billion laughs has %d bytes, want nothing", last, len(res.Raw))
+	}
+	for _, path := range []string{last, "**", "a"} {
+		if _, err := GetE(y, path); err == nil {
+			t.Errorf("GetE(%q) should fail", path)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("GetE(%q) error %T, want *SyntaxError", path, err)
+		}
+	}
+	if _, err := ParseDocument(y); err == nil {
+		t.Error("ParseDocument of a billion laughs should fail")
+	}
+
+	// a few levels are fine
+	if got := Get(laughs(4), "d.9.9.9.9").String(); got != "x" {
+		t.Errorf("d.9.9.9.9 = %q, want x", got)
+	}
+}
+
+func TestAnchors(t *testing.T) {
+	anchors := Anchors(testAnchorYAML)
+	if len(anchors) != 2 {
+		t.Fatalf("len(Anchors) = %d, want 2", len(anchors))
+	}
+	if anchors[0].Name != "base" || anchors[0].Path != "base" || anchors[0].Value.Get("port").Int() != 5432 {
+		t.Errorf("anchors[0] = %+v", anchors[0])
+	}
+	if anchors[1].Name != "main" || anchors[1].Path != "ports.0" || anchors[1].Value.Int() != 80 {
+		t.Errorf("anchors[1] = %+v", anchors[1])
+	}
+}
+
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		path   string
+		line   int
+		column int
+	}{
+		{"name.first", 3, 10},
+		{"age", 5, 6},
+		{"children.1", 8, 5},
+		{"friends.1.last", 20, 11},
+		{"friends.#(first==\"Jane\").age", 27, 10},
+		{"friends.2.nets.1", 30, 9},
+		{"name", 3, 3},
+	}
+
+	for _, tt := range tests {
+		res := Get(testYAML, tt.path)
+		if res.Line != tt.line || res.Column != tt.column {
+			t.Errorf("Get(%q) at %d:%d, want %d:%d", tt.path, res.Line, res.Column, tt.line, tt.column)
+		}
+		if testYAML[res.Index:res.Index+len(res.Raw)] != res.Raw {
+			t.Errorf("Get(%q).Index = %d does not point at %q", tt.path, res.Index, res.Raw)
+		}
+	}
+}
+
+func TestChildPositions(t *testing.T) {
+	for i, child := range Get(testYAML, "children").Array() {
+		if child.Line != 7+i || child.Column != 5 {
+			t.Errorf("children.%d at %d:%d, want %d:5", i, child.Line, child.Column, 7+i)
+		}
+	}
+
+	Get(testYAML, "friends.0").ForEach(func(key, value Result) bool {
+		if key.Str == "last" && (key.Line != 13 || key.Column != 5 || value.Column != 11) {
+			t.Errorf("friends.0.last key at %d:%d, value at column %d", key.Line, key.Column, value.Column)
+		}
+		return true
+	})
+
+	nets := Get(testYAML, "friends").Array()[1].Map()["nets"]
+	if nets.Get("1").Line != 24 {
+		t.Errorf("friends.1.nets.1 at line %d, want 24", nets.Get("1").Line)
+	}
+
+	// aliased values are found where the anchor is defined
+	if res := Get(testAnchorYAML, "dev.host"); res.Line != Get(testAnchorYAML, "base.host").Line {
+		t.Errorf("dev.host at line %d, want the line of base.host", res.Line)
+	}
+}
+
+func TestMatchPaths(t *testing.T) {
+	services := `
+services:
+  api:
+    port: 8080
+  db.main:
+    port: 5432
+`
+	tests := []struct {
+		yaml  string
+		path  string
+		paths string
+	}{
+		{testYAML, `friends.#(age>45)#.first`, "friends.1.first,friends.2.first"},
+		{testYAML, `friends.#(age>45)#`, "friends.1,friends.2"},
+		{testYAML, `friends.#.nets|0`, "friends.0.nets.0,friends.1.nets.0,friends.2.nets.0"},
+		{testYAML, `friends.last`, "friends.0.last,friends.1.last,friends.2.last"},
+		{testYAML, `friends.#(age>99)#`, ""},
+		{services, `services.#(port>0)#.port`, `services.api.port,services.db\.main.port`},
+	}
+	for _, tt := range tests {
+		res := Get(tt.yaml, tt.path)
+		if got := strings.Join(res.Paths, ","); got != tt.paths {
+			t.Errorf("Get(%q).Paths = %s, want %s", tt.path, got, tt.paths)
+		}
+		if len(res.Indexes) != len(res.Paths) {
+			t.Fatalf("Get(%q) has %d indexes for %d paths", tt.path, len(res.Indexes), len(res.Paths))
+		}
+		for i, path := range res.Paths {
+			if match := Get(tt.yaml, path); match.Index != res.Indexes[i] {
+				t.Errorf("Get(%q).Indexes[%d] = %d, want the index %d of %s", tt.path, i, res.Indexes[i], match.Index, path)
+			}
+		}
+	}
+
+	// the paths of values that are not in the document are not known
+	for _, path := range []string{`friends.#(age>45)#|@reverse`, `friends.#.nets.#(=="fb")#`,
+		`friends|@reverse|#.first`, `friends|@reverse.#.first`, `friends|@this|#.first`, `friends|@reverse|#(age>45)#`} {
+		if res := Get(testYAML, path); res.Paths != nil || res.Indexes != nil {
+			t.Errorf("Get(%q) has paths %v", path, res.Paths)
+		}
+	}
+}
+
+func TestResultPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"name.first", "name.first"},
+		{`friends.#(last=="Murphy").first`, "friends.0.first"},
+		{`friends.#(age>45).nets.1`, "friends.1.nets.1"},
+		{"child*", "children"},
+		{"friends|1|first", "friends.1.first"},
+		{`fav\.movie`, `fav\.movie`},
+		{"", "@this"},
+		{"friends.#", ""},
+		{"friends.#.first", ""},
+		{"children|@reverse|0", ""},
+		{"friends|@reverse|0.first", ""},
+		{"friends|@reverse.1", ""},
+	}
+	for _, tt := range tests {
+		res := Get(testYAML, tt.path)
+		if got := res.Path(); got != tt.want {
+			t.Errorf("Get(%q).Path() = %q, want %q", tt.path, got, tt.want)
+		}
+		if tt.want != "" && tt.want != "@this" && Get(testYAML, tt.want).Raw != res.Raw {
+			t.Errorf("Get(%q) is not found again at %q", tt.path, tt.want)
+		}
+	}
+
+	var paths []string
+	Get(testYAML, "friends.#(age>45)#").ForEach(func(_, friend Result) bool {
+		friend.ForEach(func(_, value Result) bool {
+			paths = append(paths, value.Path())
+			return false
+		})
+		paths = append(paths, friend.Get("nets.0").Path())
+		return true
+	})
+	Get(testYAML, "friends|@reverse").ForEach(func(_, friend Result) bool {
+		if path := friend.Get("first").Path(); path != "" {
+			t.Errorf("Path() after @reverse = %q, want nothing", path)
+		}
+		return true
+	})
+	Get(testYAML, "friends|@reverse|#.first").ForEach(func(_, first Result) bool {
+		if path := first.Path(); path != "" {
+			t.Errorf("Path() of %s after @reverse = %q, want nothing", first.Raw, path)
+		}
+		return true
+	})
+	want := "friends.1.first,friends.1.nets.0,friends.2.first,friends.2.nets.0"
+	if got := strings.Join(paths, ","); got != want {
+		t.Errorf("paths = %s, want %s", got, want)
+	}
+
+	if got := Parse(testYAML).Get("name").Map()["last"].Path(); got != "name.last" {
+		t.Errorf("Path() of a Map value = %q, want name.last", got)
+	}
+	if got := GetDocuments(testStreamYAML)[1].Get("kind").Path(); got != "..1.kind" {
+		t.Errorf("Path() in the second document = %q, want ..1.kind", got)
+	}
+	doc, _ := ParseDocument(testYAML)
+	if got := doc.Get("friends.#(age>45).last").Path(); got != "friends.1.last" {
+		t.Errorf("Document Path() = %q, want friends.1.last", got)
+	}
+}
+
+func TestResultPathEscaping(t *testing.T) {
+	keys := []string{"a*b", "**", "a?", "{x}", "[y]", "x{y}", "a.b", "#", "a|b", "@this", `a\b`, "12:30", "-1", "k"}
+	var b strings.Builder
+	for i, key := range keys {
+		b.WriteString(strconv.Quote(key) + ": " + strconv.Itoa(i) + "\n")
+	}
+	y := b.String()
+	Parse(y).ForEach(func(key, value Result) bool {
+		path := value.Path()
+		if res := Get(y, path); res.Raw != value.Raw || res.Path() != path {
+			t.Errorf("Get(%q) for key %q = %q at %q, want %q", path, key.String(), res.Raw, res.Path(), value.Raw)
+		}
+		return true
+	})
+	if res := Get(y, `a\*b`); res.Int() != 0 {
+		t.Errorf(`Get(a\*b) = %q, want 0`, res.Raw)
+	}
+	if res := Get(y, `a\*`); res.Exists() {
+		t.Errorf(`Get(a\*) = %q, want nothing`, res.Raw)
+	}
+	if res := Get(y, "a*"); !res.IsArray() || len(res.Array()) != 5 {
+		t.Errorf("Get(a*) = %q, want the 5 keys starting with a", res.Raw)
+	}
+}
+
+func TestDocumentPositions(t *testing.T) {
+	if res := Get(testStreamYAML, "..2.metadata.name"); res.Line != 14 || res.Column != 9 {
+		t.Errorf("..2.metadata.name at %d:%d, want 14:9", res.Line, res.Column)
+	}
+	docs := GetDocuments(testStreamYAML)
+	if res := docs[1].Get("kind"); res.Line != 8 || res.Column != 7 ||
+		testStreamYAML[res.Index:res.Index+len(res.Raw)] != "Deployment" {
+		t.Errorf("second document kind at %d:%d (index %d)", res.Line, res.Column, res.Index)
+	}
+}
+
+func TestGetE(t *testing.T) {
+	res, err := GetE(testYAML, "friends.1.first")
+	if err != nil || res.String() != "Roger" {
+		t.Errorf("GetE(friends.1.first) = %q, %v", res.String(), err)
+	}
+
+	tests := []struct {
+		yaml    string
+		path    string
+		segment string
+		check   func(err error) bool
+	}{
+		{testYAML, "name.middle", "middle", func(err error) bool { e, ok := err.(*KeyError); return ok && e.Segment == "middle" }},
+		{testYAML, "age.years", "years", func(err error) bool { _, ok := err.(*KeyError); return ok }},
+		{testYAML, "friends.#(first==\"Bob\")", "#(first==\"Bob\")", func(err error) bool { _, ok := err.(*KeyError); return ok }},
+		{testYAML, "children.3", "3", func(err error) bool { e, ok := err.(*IndexError); return ok && e.Index == 3 && e.Len == 3 }},
+		{testYAML, "children|@nope", "@nope", func(err error) bool { e, ok := err.(*ModifierError); return ok && e.Name == "nope" }},
+		{testYAML, "friends.#(age>)", "#(age>)", func(err error) bool { _, ok := err.(*QueryError); return ok }},
+		{testYAML, "friends.#(age>40", "#(age>40", func(err error) bool { _, ok := err.(*QueryError); return ok }},
+		{"name: [Tom", "name", "", func(err error) bool { _, ok := err.(*SyntaxError); return ok }},
+	}
+
+	for _, tt := range tests {
+		res, err := GetE(tt.yaml, tt.path)
+		if err == nil || res.Exists() {
+			t.Errorf("GetE(%q) = %q, %v, want an error", tt.path, res.String(), err)
+			continue
+		}
+		if !tt.check(err) {
+			t.Errorf("GetE(%q) error %T: %v", tt.path, err, err)
+		}
+		if tt.segment != "" && !strings.Contains(err.Error(), strconv.Quote(tt.segment)) {
+			t.Errorf("GetE(%q) error %q does not name the segment", tt.path, err)
+		}
+	}
+
+	// missing items of a projection are left out rather than failing
+	if res, err := GetE(testYAML, "friends.#.nets.2"); err != nil || len(res.Array()) != 1 {
+		t.Errorf("GetE(friends.#.nets.2) = %q, %v", res.Raw, err)
+	}
+}
+
+func TestParseE(t *testing.T) {
+	if res, err := ParseE(testStreamYAML); err != nil || res.Get("kind").String() != "Service" {
+		t.Errorf("ParseE = %q, %v", res.Get("kind").String(), err)
+	}
+	invalid := []string{
+		"a: 1\n---\nb: [\n",
+		"key: value\n  bad indent: x\nc: 1\n",
+		"- a\nb: c\n",
+	}
+	for _, yaml := range invalid {
+		if _, err := ParseE(yaml); err == nil {
+			t.Errorf("ParseE(%q) should fail", yaml)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("ParseE(%q) error %T, want *SyntaxError", yaml, err)
+		}
+	}
+}
+
+func TestGetEInvalid(t *testing.T) {
+	// the fast path would find a before reaching the error
+	yaml := "a: 1\nb: [\n"
+	for _, path := range []string{"a", "", "a|@this"} {
+		if _, err := GetE(yaml, path); err == nil {
+			t.Errorf("GetE(%q) should fail", path)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("GetE(%q) error %T, want *SyntaxError", path, err)
+		}
+	}
+	if res := Get(yaml, "a"); res.Int() != 1 {
+		t.Errorf("Get(a) = %q, want 1", res.Raw)
+	}
+	doc, err := ParseDocument("a: 1\n---\nb: [\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := doc.GetE("..0.a"); err == nil {
+		t.Error("Document.GetE(..0.a) should fail on an invalid second document")
+	} else if _, ok := err.(*SyntaxError); !ok || res.Int() != 1 {
+		t.Errorf("Document.GetE(..0.a) = %q, %T", res.Raw, err)
+	}
+}
+
+func TestModifierChain(t *testing.T) {
+	if res := Get(testYAML, "children|@reverse.0"); res.String() != "Jack" {
+		t.Errorf("children|@reverse.0 = %q, want %q", res.String(), "Jack")
+	}
+	if res := Get(testYAML, "children|@reverse|@reverse|0"); res.String() != "Sara" {
+		t.Errorf("children|@reverse|@reverse|0 = %q, want %q", res.String(), "Sara")
+	}
+	if res := Get(testYAML, "name|last"); res.String() != "Anderson" {
+		t.Errorf("name|last = %q, want %q", res.String(), "Anderson")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	paths := []string{
+		"name.last",
+		".age",
+		"children.1",
+		"friends.#.first",
+		`friends.#(last=="Murphy")#.first`,
+		"children|@reverse.0",
+		"@this",
+		"..0.name.first",
+		"",
+	}
+	for _, path := range paths {
+		p, err := Compile(path)
+		if err != nil {
+			t.Errorf("Compile(%q) error: %v", path, err)
+			continue
+		}
+		if p.String() != path {
+			t.Errorf("String() = %q, want %q", p.String(), path)
+		}
+		if got, want := p.Get(testYAML), Get(testYAML, path); got.Raw != want.Raw || got.Type != want.Type {
+			t.Errorf("Compile(%q).Get = %q, want %q", path, got.Raw, want.Raw)
+		}
+		if got := p.GetBytes([]byte(testYAML)); got.String() != Get(testYAML, path).String() {
+			t.Errorf("Compile(%q).GetBytes = %q", path, got.String())
+		}
+	}
+
+	if _, err := Compile("friends.#(age>40"); err == nil {
+		t.Error("Compile with an unclosed query should fail")
+	}
+
+	friends := Get(testYAML, "friends")
+	if res := friends.GetPath(MustCompile("1.last")); res.String() != "Craig" {
+		t.Errorf("GetPath(1.last) = %q, want %q", res.String(), "Craig")
+	}
+}
+
+func TestCompileConcurrent(t *testing.T) {
+	p := MustCompile(`friends.#(age>45)#.first`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if res := p.Get(testYAML); len(res.Array()) != 2 {
+					t.Errorf("concurrent Get = %q", res.Raw)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDocument(t *testing.T) {
+	doc, err := ParseDocument(testYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"name.last", "age", "children.#", "friends.#.first", `friends.#(age>45)#.last`, "children|@reverse.0", "fav\\.movie"} {
+		if got, want := doc.Get(path), Get(testYAML, path); got.String() != want.String() {
+			t.Errorf("doc.Get(%q) = %q, want %q", path, got.String(), want.String())
+		}
+	}
+
+	var keys []string
+	doc.ForEach(func(key, value Result) bool {
+		keys = append(keys, key.String())
+		return true
+	})
+	if strings.Join(keys, ",") != "name,age,children,fav.movie,friends" {
+		t.Errorf("doc.ForEach keys = %v", keys)
+	}
+
+	if res := doc.GetPath(MustCompile("friends.1")).Get("nets.0"); res.String() != "fb" || res.Line != 23 {
+		t.Errorf("friends.1.nets.0 = %q at line %d", res.String(), res.Line)
+	}
+	if _, err := doc.GetE("name.middle"); err == nil {
+		t.Error("doc.GetE(name.middle) should fail")
+	}
+	if v, ok := doc.Get("name").Value().(map[string]interface{}); !ok || v["first"] != "Tom" {
+		t.Errorf("doc.Get(name).Value() = %v", doc.Get("name").Value())
+	}
+
+	if _, err := ParseDocument("a: [1"); err == nil {
+		t.Error("ParseDocument should fail on invalid yaml")
+	}
+}
+
+func TestDocumentStream(t *testing.T) {
+	doc, err := ParseDocumentBytes([]byte(testStreamYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := doc.Get("..#"); res.Int() != 3 {
+		t.Errorf("doc.Get(..#) = %d, want 3", res.Int())
+	}
+	if res := doc.Get("kind"); res.String() != "Service" {
+		t.Errorf("doc.Get(kind) = %q, want %q", res.String(), "Service")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := doc.Get(`..#(kind=="Deployment")#.metadata.name`); res.Get("1").String() != "worker" {
+				t.Errorf("concurrent stream Get = %q", res.Raw)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+const scalarsYAML = `plain: hello world
+quoted: "say \"hi\""
+single: 'it''s'
+flow: {a: 1, b: [x, "y, z"]}
+literal: |
+  line one
+  line two
+folded: >-
+  folded
+  text
+multi: first
+  second
+empty:
+tilde: ~
+hex: 0x1F
+yes: yes
+list:
+- a
+- b: 1
+  c: 2
+nested:
+  deep:
+    key: value # comment
+`
+
+func TestRawIsSubstring(t *testing.T) {
+	tests := []struct {
+		path string
+		raw  string
+		str  string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"quoted", `"say \"hi\""`, `say "hi"`},
+		{"single", "'it''s'", "it's"},
+		{"flow", `{a: 1, b: [x, "y, z"]}`, `{a: 1, b: [x, "y, z"]}`},
+		{"flow.b.1", `"y, z"`, "y, z"},
+		{"literal", "|\n  line one\n  line two", "line one\nline two\n"},
+		{"folded", ">-\n  folded\n  text", "folded text"},
+		{"multi", "first\n  second", "first second"},
+		{"hex", "0x1F", "31"},
+		{"yes", "yes", "yes"},
+		{"nested", "  deep:\n    key: value", "  deep:\n    key: value"},
+		{"nested.deep.key", "value", "value"},
+	}
+
+	doc, err := ParseDocument(scalarsYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		for _, res := range []Result{Get(scalarsYAML, tt.path), doc.Get(tt.path)} {
+			if res.Raw != tt.raw || res.String() != tt.str {
+				t.Errorf("Get(%q) = %q, %q, want %q, %q", tt.path, res.Raw, res.String(), tt.raw, tt.str)
+			}
+			if scalarsYAML[res.Index:res.Index+len(res.Raw)] != res.Raw {
+				t.Errorf("Get(%q).Index = %d does not point at %q", tt.path, res.Index, res.Raw)
+			}
+		}
+	}
+
+	if res := Get(scalarsYAML, "tilde"); res.Type != Null || res.Raw != "~" {
+		t.Errorf("Get(tilde) = %v %q, want Null \"~\"", res.Type, res.Raw)
+	}
+	if res := Get(scalarsYAML, "empty"); res.Type != Null || res.Raw != "" {
+		t.Errorf("Get(empty) = %v %q, want Null \"\"", res.Type, res.Raw)
+	}
+	// a mapping that starts on the line of its dash keeps its indentation
+	if res := Get(scalarsYAML, "list.1"); res.Raw != "  b: 1\n  c: 2" || res.Get("c").Line != 20 {
+		t.Errorf("Get(list.1) = %q, c at line %d", res.Raw, res.Get("c").Line)
+	}
+}
+
+func TestFastPathMatchesTree(t *testing.T) {
+	doc, err := ParseDocument(scalarsYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	var walk func(prefix string, res Result)
+	walk = func(prefix string, res Result) {
+		res.ForEach(func(key, value Result) bool {
+			path := prefix + key.String()
+			paths = append(paths, path)
+			if value.Type == YAML {
+				paths = append(paths, path+".#")
+				walk(path+".", value)
+			}
+			if n := len(value.Array()); value.IsArray() && n > 0 {
+				// negative indexes count from the end
+				paths = append(paths, path+".-1", path+"."+strconv.Itoa(-n))
+			}
+			return true
+		})
+	}
+	walk("", doc.Get(""))
+
+	for _, path := range paths {
+		fast, tree := Get(scalarsYAML, path), doc.Get(path)
+		if fast.Type != tree.Type || fast.Raw != tree.Raw || fast.Str != tree.Str || fast.Num != tree.Num ||
+			fast.Index != tree.Index || fast.Line != tree.Line || fast.Column != tree.Column ||
+			fast.Path() != tree.Path() {
+			t.Errorf("Get(%q) = %+v, want %+v", path, fast, tree)
+		}
+	}
+}
+
+func TestFastPathScanning(t *testing.T) {
+	tests := []struct {
+		yaml string
+		path string
+	}{
+		{"a: | # empty literal\nb: 1\n", "a"},
+		{"a: | # empty literal\nb: 1\n", "b"},
+		{"a: >-\n\nb: 1\n", "a"},
+		{"list:\n  - name: a\n    args: [x,\n  y]\n  - name: b\n", "list.#"},
+		{"list:\n  - name: a\n    args: [x,\n  y]\n  - name: b\n", "list.1.name"},
+		{"list:\n  - name: a\n    note: \"x\n  y\"\n  - name: b\n", "list.1.name"},
+		{"m:\n  a:\n    b: {x: 1,\n  y: 2}\n  c: 3\n", "m.c"},
+	}
+	for _, tt := range tests {
+		doc, err := ParseDocument(tt.yaml)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fast, tree := Get(tt.yaml, tt.path), doc.Get(tt.path)
+		if fast.Type != tree.Type || fast.Raw != tree.Raw || fast.Str != tree.Str || fast.Line != tree.Line {
+			t.Errorf("Get(%q, %q) = %+v, want %+v", tt.yaml, tt.path, fast, tree)
+		}
+	}
+}
+
+func FuzzFastPath(f *testing.F) {
+	f.Add(scalarsYAML, "flow.b.1")
+	f.Add(testYAML, "friends.1.nets.-1")
+	f.Add("a: | # empty literal\nb: 1\n", "a")
+	f.Add("list:\n  - name: a\n    args: [x,\n  y]\n  - name: b\n", "list.#")
+	f.Fuzz(func(t *testing.T, yaml, path string) {
+		fast := Get(yaml, path)
+		if hasComplexFeatures(path) || !Valid(yaml) {
+			return
+		}
+		doc, err := ParseDocument(yaml)
+		if err != nil {
+			return
+		}
+		tree := doc.Get(path)
+		if fast.Type != tree.Type || fast.Raw != tree.Raw || fast.Str != tree.Str {
+			t.Errorf("Get(%q, %q) = %+v, want %+v", yaml, path, fast, tree)
+		}
+	})
+}
+
+func TestSlices(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"children.-1", "Jack"},
+		{"children.-3", "Sara"},
+		{"children.-4", ""},
+		{"friends.-1.first", "Jane"},
+		{"children.1:", "Alex,Jack"},
+		{"children.:-1", "Sara,Alex"},
+		{"children.::2", "Sara,Jack"},
+		{"children.::-1", "Jack,Alex,Sara"},
+		{"children.-2::-1", "Alex,Sara"},
+		{"children.0:10", "Sara,Alex,Jack"},
+		{"children.2:1", ""},
+		{"friends.1:3.#.first", "Roger,Jane"},
+		{"friends.::2.last", "Murphy,Murphy"},
+		{"friends.0:2.#(age>50)#.first", "Roger"},
+		{"friends.1:.#.nets.-1", "tw,tw"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, res := range Get(testYAML, tt.path).Array() {
+			got = append(got, res.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	if res := Get(testYAML, "friends.-1"); res.Path() != "friends.2" {
+		t.Errorf("friends.-1 is at %q, want friends.2", res.Path())
+	}
+	if res := Get(testYAML, "children.::-2"); strings.Join(res.Paths, ",") != "children.2,children.0" {
+		t.Errorf("children.::-2 paths = %v", res.Paths)
+	}
+	if _, err := GetE(testYAML, "children.-4"); err == nil {
+		t.Error("children.-4 was found")
+	}
+
+	// a slice is a key of a mapping
+	if got := Get("ports:\n  \"80:8080\": web\n", "ports.80:8080").String(); got != "web" {
+		t.Errorf("ports.80:8080 = %q, want web", got)
+	}
+}
+
+func TestRecursiveDescent(t *testing.T) {
+	yaml := `
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: app
+          image: app:v1
+          sidecar:
+            image: envoy:1.2
+        - name: log
+          image: fluent:2
+`
+	tests := []struct {
+		path  string
+		want  string
+		paths string
+	}{
+		{"**.image", "busybox:1.36,app:v1,envoy:1.2,fluent:2",
+			"spec.template.spec.initContainers.0.image,spec.template.spec.containers.0.image," +
+				"spec.template.spec.containers.0.sidecar.image,spec.template.spec.containers.1.image"},
+		{"spec..image", "busybox:1.36,app:v1,envoy:1.2,fluent:2",
+			"spec.template.spec.initContainers.0.image,spec.template.spec.containers.0.image," +
+				"spec.template.spec.containers.0.sidecar.image,spec.template.spec.containers.1.image"},
+		{"**.sidecar.image", "envoy:1.2", "spec.template.spec.containers.0.sidecar.image"},
+		{"**.containers.-1.name", "log", "spec.template.spec.containers.1.name"},
+		{`**.#(name=="log").image`, "fluent:2", "spec.template.spec.containers.1.image"},
+		{"spec.template.spec.containers.0.**", "app,app:v1,image: envoy:1.2,envoy:1.2",
+			"spec.template.spec.containers.0.name,spec.template.spec.containers.0.image," +
+				"spec.template.spec.containers.0.sidecar,spec.template.spec.containers.0.sidecar.image"},
+		{"**.missing", "", ""},
+	}
+	for _, tt := range tests {
+		res := Get(yaml, tt.path)
+		var got []string
+		for _, match := range res.Array() {
+			got = append(got, strings.TrimSpace(match.String()))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+		if strings.Join(res.Paths, ",") != tt.paths {
+			t.Errorf("Get(%q).Paths = %v, want %s", tt.path, res.Paths, tt.paths)
+		}
+	}
+
+	// an escaped dot is not a recursive descent
+	if got := Get("a.:\n  b: 1\n", `a\..b`).String(); got != "1" {
+		t.Errorf(`Get(a\..b) = %q, want 1`, got)
+	}
+}
+
+func TestMultipath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`{name.first,age,"kids":children}`, "first: Tom\nage: 37\nkids:\n    - Sara\n    - Alex\n    - Jack\n"},
+		{`[name.first,age]`, "- Tom\n- 37\n"},
+		{`{name.first,missing,"murphys":friends.#(last=="Murphy")#.first}`, "first: Tom\nmurphys:\n    - Dale\n    - Jane\n"},
+		{`friends.#.{first,"net":nets.0}`, "- first: Dale\n  net: ig\n- first: Roger\n  net: fb\n- first: Jane\n  net: ig\n"},
+		{`{name.last,"n":[age,children.-1]}`, "last: Anderson\nn:\n    - 37\n    - Jack\n"},
+		{`{"kids":children|@reverse}`, "kids:\n    - Jack\n    - Alex\n    - Sara\n"},
+		{`{"a, b":age, "c":name.first}`, "a, b: 37\nc: Tom\n"},
+		{`{fav\.movie}`, "fav.movie: Deer Hunter\n"},
+		{`{name.first,age}.age`, "37"},
+		{`{}`, "{}\n"},
+		{`{age|@this,name.first|@reverse}`, "age: 37\nfirst: Tom\n"},
+		{`{children|@reverse|0}`, "children: Jack\n"},
+		{`{name.first,"first2":friends.0.first}`, "first: Tom\nfirst2: Dale\n"},
+	}
+	for _, tt := range tests {
+		res := Get(testYAML, tt.path)
+		if res.Raw != tt.want {
+			t.Errorf("Get(%q) = %q, want %q", tt.path, res.Raw, tt.want)
+		}
+		if tt.want != "37" && !Valid(res.Raw) {
+			t.Errorf("Get(%q) is not valid yaml", tt.path)
+		}
+	}
+
+	if res := Get(testYAML, `{name.first,"kid":children.1}`); strings.Join(res.Paths, ",") != "name.first,children.1" {
+		t.Errorf("multipath paths = %v", res.Paths)
+	}
+
+	for _, path := range []string{`{name`, `{name,}`, `["k":name]`, `{"k" name}`, `{#(a==}`,
+		`{name.first,friends.0.first}`, `{age,"age":name}`} {
+		if _, err := Compile(path); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", path)
+		} else if _, ok := err.(*QueryError); !ok {
+			t.Errorf("Compile(%q) error = %T, want *QueryError", path, err)
+		}
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	yaml := `zeta: 1
+alpha: 2
+mid:
+  z2: a
+  a2: b
+items:
+  - {zz: 1, aa: 2}
+  - {bb: 3, zz: 4}
+`
+	join := func(res Result) string {
+		var parts []string
+		res.ForEach(func(key, value Result) bool {
+			parts = append(parts, key.String()+"="+value.String())
+			return true
+		})
+		return strings.Join(parts, ",")
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"mid", "z2=a,a2=b"},
+		{"@this|@keys", "0=zeta,1=alpha,2=mid,3=items"},
+		{"mid|@values", "0=a,1=b"},
+		{"mid|@reverse", "a2=b,z2=a"},
+		{"items|@join", "zz=4,aa=2,bb=3"},
+		{"*a", "0=1,1=2"},
+	}
+
+	for i := 0; i < 20; i++ {
+		for _, tt := range tests {
+			if got := join(Get(yaml, tt.path)); got != tt.want {
+				t.Fatalf("Get(%q) = %s, want %s", tt.path, got, tt.want)
+			}
+		}
+	}
+
+	value := Get(yaml, "@this").OrderedValue()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"zeta":1,"alpha":2,"mid":{"z2":"a","a2":"b"},"items":[{"zz":1,"aa":2},{"bb":3,"zz":4}]}`
+	if string(data) != want {
+		t.Errorf("OrderedValue() = %s, want %s", data, want)
+	}
+	out, err := yamlv3.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "zeta: 1\nalpha: 2\nmid:\n") {
+		t.Errorf("OrderedValue() encoded to %q", out)
+	}
+}
+
+func TestSortModifier(t *testing.T) {
+	yaml := `
+tags: [v10, V2, v1, b, 3]
+versions: [file10, File2, file1]
+ports:
+  web: 8080
+  db: 5432
+  api: 9000
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"children|@sort", "Alex,Jack,Sara"},
+		{`friends|@sort:{"by":"last"}|0.first`, "Roger"},
+		{`friends|@sort:{"by":"age","desc":true}.#.first`, "Roger,Jane,Dale"},
+		{`friends|@sort:age|#.age`, "44,47,68"},
+		{`friends|@sort:{"by":"last","desc":true}.#.first`, "Dale,Jane,Roger"},
+		{"tags|@sort", "3,V2,b,v1,v10"},
+		{`tags|@sort:{"nocase":true}`, "3,b,v1,v10,V2"},
+		{`tags|@sort:{"nocase":true,"natural":true}`, "3,b,v1,V2,v10"},
+		{`versions|@sort:{"natural":true}`, "File2,file1,file10"},
+		{`versions|@sort:{"natural":true,"nocase":true,"desc":true}`, "file10,File2,file1"},
+		{"ports|@sort.@keys", "db,web,api"},
+		{"age|@sort", "37"},
+	}
+	for _, tt := range tests {
+		var got []string
+		Get(testYAML+yaml, tt.path).ForEach(func(_, value Result) bool {
+			got = append(got, value.String())
+			return true
+		})
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateModifiers(t *testing.T) {
+	yaml := `
+big: [9007199254740993, 1, 12345678901234567890123]
+mixed: [1, 2.5, "3", null, true]
+empty: []
+sizes:
+  a: 3
+  b: 1
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"children|@count", "3"},
+		{"friends|@count:age", "3"},
+		{"friends|@count:pets", "0"},
+		{"friends|@sum:age", "159"},
+		{"friends|@avg:age", "53"},
+		{"friends|@min:age", "44"},
+		{"friends|@max:age", "68"},
+		{"big|@sum", "12345687908433822631117"},
+		{"big.0:2|@sum", "9007199254740994"},
+		{"big|@max", "12345678901234567890123"},
+		{"big.0:2|@avg", "4503599627370497"},
+		{"mixed|@count", "5"},
+		{"mixed|@sum", "3.5"},
+		{"mixed|@avg", "1.75"},
+		{"mixed|@max", "2.5"},
+		{"empty|@sum", "0"},
+		{"sizes|@sum", "4"},
+		{"sizes|@min", "1"},
+	}
+	for _, tt := range tests {
+		res := Get(testYAML+yaml, tt.path)
+		if res.Raw != tt.want || res.Type != Number {
+			t.Errorf("Get(%q) = %s (%v), want %s", tt.path, res.Raw, res.Type, tt.want)
+		}
+	}
+
+	for _, path := range []string{"empty|@avg", "empty|@min", "mixed.2:|@max"} {
+		if res := Get(testYAML+yaml, path); res.Exists() {
+			t.Errorf("Get(%q) = %s, want no result", path, res.Raw)
+		}
+	}
+	if res := Get(testYAML+yaml, "big|@sum"); res.String() != "12345687908433822631117" {
+		t.Errorf("big|@sum String() = %s", res.String())
+	}
+}
+
+func TestGroupModifiers(t *testing.T) {
+	yaml := `
+containers:
+  - name: web
+    image: nginx
+  - name: api
+    image: app
+  - name: proxy
+    image: nginx
+  - name: init
+  - name: sidecar
+    image: app
+sizes: [2, 1, 2.0, "2"]
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"containers|@groupby:image|@keys", "nginx,app"},
+		{"containers|@groupby:image|nginx.#.name", "web,proxy"},
+		{`containers|@groupby:"image".app.#.name`, "api,sidecar"},
+		{"containers|@countby:image", "nginx=2,app=2"},
+		{"containers|@countby:name|@keys", "web,api,proxy,init,sidecar"},
+		{"sizes|@countby", "2=2,1=1,2=1"},
+		{"friends|@countby:last", "Murphy=2,Craig=1"},
+		{"name|@groupby:first", "first=Tom,last=Anderson"},
+	}
+	for _, tt := range tests {
+		var got []string
+		res := Get(testYAML+yaml, tt.path)
+		res.ForEach(func(key, value Result) bool {
+			if res.IsObject() {
+				got = append(got, key.String()+"="+value.String())
+			} else {
+				got = append(got, value.String())
+			}
+			return true
+		})
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	res := Get(testYAML+yaml, "containers|@groupby:image")
+	if got := res.Get("nginx.1.name").String(); got != "proxy" {
+		t.Errorf("nginx.1.name = %q, want proxy", got)
+	}
+	if res.Get("init").Exists() || res.Get("null").Exists() {
+		t.Errorf("@groupby kept an item without the key: %s", res.Raw)
+	}
+
+	// the members are the items of the document, with their positions
+	want := Get(testYAML+yaml, "containers.2")
+	for _, path := range []string{"containers|@groupby:image|nginx.1", "containers|@groupby:image|@this.nginx.1"} {
+		got := Get(testYAML+yaml, path)
+		if got.Raw != want.Raw || got.Line != want.Line || got.Index != want.Index || got.Line == 0 {
+			t.Errorf("Get(%q) = %q at line %d, index %d, want line %d, index %d",
+				path, got.Raw, got.Line, got.Index, want.Line, want.Index)
+		}
+	}
+	doc, err := ParseDocument(testYAML + yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Get("containers|@groupby:image").Get("app.1.name"); got.Line == 0 || got.Index != strings.Index(testYAML+yaml, "sidecar") {
+		t.Errorf("app.1.name at line %d, index %d", got.Line, got.Index)
+	}
+}
+
+func TestSetModifiers(t *testing.T) {
+	yaml := `
+allowed: [read, write, delete, read]
+requested: [write, admin, read]
+numbers: [1, 2.0, 2, "1"]
+rules:
+  - {verb: get, resource: pods}
+  - {resource: pods, verb: get}
+  - {verb: list, resource: pods}
+  - {verb: get, resource: nodes}
+blocked:
+  - resource: pods
+    verb: list
+spec:
+  ports: [80, 443]
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"allowed|@uniq", "read,write,delete"},
+		{"numbers|@uniq|#", "3"},
+		{"rules|@uniq.#.verb", "get,list,get"},
+		{"rules|@uniq:verb|#.resource", "pods,pods"},
+		{"rules|@uniq:resource|#.verb", "get,get"},
+		{`friends|@uniq:"last".#.first`, "Dale,Roger"},
+		{"allowed|@union:requested", "read,write,delete,admin"},
+		{"allowed|@intersect:requested", "read,write"},
+		{"allowed|@difference:requested", "delete"},
+		{"requested|@difference:allowed", "admin"},
+		{`rules|@difference:blocked|#.verb`, "get,get"},
+		{`rules|@intersect:"blocked".#.verb`, "list"},
+		{"spec.ports|@union:[443, 8080]", "80,443,8080"},
+		{"spec.ports|@difference:spec.ports", ""},
+		{"allowed|@reverse|@difference:[write, read]", "delete"},
+		{"allowed|@reverse|@difference:requested", "delete"},
+		{"{allowed,requested}|@this.allowed|@intersect:requested", "read,write"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, res := range Get(testYAML+yaml, tt.path).Array() {
+			got = append(got, res.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	doc, err := ParseDocument(testYAML + yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Get("allowed|@difference:requested").Array(); len(got) != 1 || got[0].String() != "delete" {
+		t.Errorf("Document.Get(@difference) = %v, want [delete]", got)
+	}
+
+	// the argument is a path in the value that Get is called on
+	for _, spec := range []Result{doc.Get("spec"), Get(testYAML+yaml, "spec"), Parse(testYAML + yaml).Get("spec")} {
+		if got := spec.Get("ports|@union:ports").Array(); len(got) != 2 || got[1].Int() != 443 {
+			t.Errorf("spec.Get(ports|@union:ports) = %v, want [80 443]", got)
+		}
+		if res := spec.Get("ports|@union:spec.ports"); res.Exists() {
+			t.Errorf("spec.Get(ports|@union:spec.ports) = %q, want nothing", res.Raw)
+		}
+	}
+
+	for _, path := range []string{"allowed|@difference:missing", "allowed|@intersect:missing", "allowed|@union:"} {
+		if res := Get(testYAML+yaml, path); res.Exists() {
+			t.Errorf("Get(%q) = %q, want nothing", path, res.Raw)
+		}
+		if _, err := GetE(testYAML+yaml, path); err == nil {
+			t.Errorf("GetE(%q) should fail", path)
+		} else if e, ok := err.(*ModifierError); !ok || e.Path != path || e.Reason == "" {
+			t.Errorf("GetE(%q) error = %v, want a *ModifierError", path, err)
+		}
+	}
+}
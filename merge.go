@@ -0,0 +1,297 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MergeOptions are the strategies of a merge.
+type MergeOptions struct {
+	// Lists maps the paths of sequences to the way they are merged:
+	//
+	//	"replace"        the sequence of a later document replaces the
+	//	                 sequence before it, which is the default
+	//	"append"         the items of the later sequence are appended
+	//	"merge:<path>"   items with the same value at path are merged,
+	//	                 the others are appended
+	//
+	// The indexes of a path are written as #, like spec.containers.#.ports.
+	Lists map[string]string
+}
+
+// Merge merges yaml documents into the first one, in order, so that the
+// values of a later document take precedence. Mappings are merged key by
+// key, and a null value deletes the key. Sequences are replaced, see
+// MergeOptions for the other strategies. Empty documents are skipped.
+//
+//	yaml, _ := gyaml.Merge(base, production, local)
+func Merge(docs ...string) (string, error) {
+	yaml, _, err := MergeOptions{}.Merge(docs...)
+	return yaml, err
+}
+
+// Merge merges yaml documents like the Merge function, with the strategies
+// of the options. The layers map has the index in docs of the document
+// that every scalar and empty collection of the merged document came from,
+// by path.
+func (o MergeOptions) Merge(docs ...string) (yaml string, layers map[string]int, err error) {
+	m, err := newMerger(o.Lists)
+	if err != nil {
+		return "", nil, err
+	}
+	var merged *node
+	for i, doc := range docs {
+		n, err := parseValue(doc)
+		if err != nil {
+			return "", nil, &SyntaxError{Err: err}
+		}
+		merged = m.add(merged, n, i)
+	}
+	if merged == nil {
+		return "", map[string]int{}, nil
+	}
+	layers = make(map[string]int)
+	m.layers(merged, location{known: true}, 0, layers)
+	return merged.marshal(), layers, nil
+}
+
+// listStrategy is the way a sequence is merged. Items are merged by the
+// value at key when it is set.
+type listStrategy struct {
+	append bool
+	key    string
+}
+
+func parseListStrategy(path, s string) (listStrategy, error) {
+	switch {
+	case s == "replace":
+		return listStrategy{}, nil
+	case s == "append":
+		return listStrategy{append: true}, nil
+	case strings.HasPrefix(s, "merge:") && len(s) > len("merge:"):
+		return listStrategy{key: s[len("merge:"):]}, nil
+	}
+	return listStrategy{}, fmt.Errorf("gyaml: path %q: unknown merge strategy %q", path, s)
+}
+
+// merger merges parsed documents, recording the document that every value
+// of the result came from.
+type merger struct {
+	lists  map[string]listStrategy
+	origin map[*node]int
+}
+
+func newMerger(lists map[string]string) (*merger, error) {
+	m := &merger{lists: make(map[string]listStrategy, len(lists)), origin: make(map[*node]int)}
+	for path, s := range lists {
+		strategy, err := parseListStrategy(path, s)
+		if err != nil {
+			return nil, err
+		}
+		m.lists[path] = strategy
+	}
+	return m, nil
+}
+
+// add merges the document n, which is the layer-th one, into merged. A null
+// document is skipped like an empty one.
+func (m *merger) add(merged, n *node, layer int) *node {
+	if n == nil || (n.kind == scalarNode && n.tag == "!!null") {
+		return merged
+	}
+	if merged == nil {
+		// the nulls of the first document are values
+		m.origin[n] = layer
+		return n
+	}
+	return m.merge(merged, n, m.origin[merged], layer, "")
+}
+
+// merge merges over, from the layer over, into base, which is from the
+// layer under unless its nodes record another one. The pattern is the
+// path of the values, with # for indexes.
+func (m *merger) merge(base, over *node, under, layer int, pattern string) *node {
+	if l, ok := m.origin[base]; ok {
+		under = l
+	}
+	switch {
+	case base.kind == mappingNode && over.kind == mappingNode:
+		return m.mergeMappings(base, over, under, layer, pattern)
+	case base.kind == sequenceNode && over.kind == sequenceNode:
+		return m.mergeSequences(base, over, under, layer, pattern)
+	}
+	return m.place(over, layer)
+}
+
+func (m *merger) mergeMappings(base, over *node, under, layer int, pattern string) *node {
+	content := make([]*node, 0, len(base.content))
+	index := make(map[string]int)
+	for i := 0; i+1 < len(base.content); i += 2 {
+		key, value := base.content[i], base.content[i+1]
+		m.keep(value, under)
+		index[key.value] = len(content)
+		content = append(content, key, value)
+	}
+	var deleted []int
+	for i := 0; i+1 < len(over.content); i += 2 {
+		key, value := over.content[i], over.content[i+1]
+		j, ok := index[key.value]
+		switch {
+		case isNull(value):
+			if ok {
+				deleted = append(deleted, j)
+			}
+		case ok:
+			content[j+1] = m.merge(content[j+1], value, under, layer, joinPath(pattern, escapeComponent(key.value)))
+		default:
+			index[key.value] = len(content)
+			content = append(content, key, m.place(value, layer))
+		}
+	}
+	if len(deleted) > 0 {
+		removed := make(map[int]bool, len(deleted))
+		for _, j := range deleted {
+			removed[j] = true
+		}
+		kept := content[:0]
+		for j := 0; j < len(content); j += 2 {
+			if !removed[j] {
+				kept = append(kept, content[j], content[j+1])
+			}
+		}
+		content = kept
+	}
+	return newMapping(content)
+}
+
+func (m *merger) mergeSequences(base, over *node, under, layer int, pattern string) *node {
+	strategy := m.lists[pattern]
+	if !strategy.append && strategy.key == "" {
+		return m.place(over, layer)
+	}
+	items := make([]*node, 0, len(base.content)+len(over.content))
+	for _, item := range base.content {
+		m.keep(item, under)
+		items = append(items, item)
+	}
+	index := make(map[string]int)
+	if strategy.key != "" {
+		for i, item := range items {
+			if value := valueAt(item, strategy.key); value.Exists() {
+				if _, ok := index[groupKey(value)]; !ok {
+					index[groupKey(value)] = i
+				}
+			}
+		}
+	}
+	for _, item := range over.content {
+		if strategy.key != "" {
+			if value := valueAt(item, strategy.key); value.Exists() {
+				if i, ok := index[groupKey(value)]; ok {
+					items[i] = m.merge(items[i], item, under, layer, joinPath(pattern, "#"))
+					continue
+				}
+			}
+		}
+		items = append(items, m.place(item, layer))
+	}
+	return newSequence(items)
+}
+
+// keep records that the value of base is from the layer under, unless it
+// records its own layer.
+func (m *merger) keep(n *node, under int) {
+	if _, ok := m.origin[n]; !ok {
+		m.origin[n] = under
+	}
+}
+
+// place returns the value n of a later document, as it is added to the
+// merged one. The null values of its mappings delete keys that it doesn't
+// have, so they are left out.
+func (m *merger) place(n *node, layer int) *node {
+	if n.kind == mappingNode {
+		content := make([]*node, 0, len(n.content))
+		for i := 0; i+1 < len(n.content); i += 2 {
+			if !isNull(n.content[i+1]) {
+				content = append(content, n.content[i], m.place(n.content[i+1], layer))
+			}
+		}
+		n = newMapping(content)
+	}
+	m.origin[n] = layer
+	return n
+}
+
+// layers records the layer of the scalars and empty collections under n,
+// which is at the location at, in layers. The layer of a node is the one it
+// records, or the one of its parent.
+func (m *merger) layers(n *node, at location, layer int, layers map[string]int) {
+	if l, ok := m.origin[n]; ok {
+		layer = l
+	}
+	if n.kind == scalarNode || len(n.content) == 0 {
+		path := at.path
+		if path == "" {
+			path = "@this"
+		}
+		layers[path] = layer
+		return
+	}
+	if n.kind == mappingNode {
+		for i := 0; i+1 < len(n.content); i += 2 {
+			m.layers(n.content[i+1], at.child(escapeComponent(n.content[i].value)), layer, layers)
+		}
+		return
+	}
+	for i, item := range n.content {
+		m.layers(item, at.child(strconv.Itoa(i)), layer, layers)
+	}
+}
+
+func isNull(n *node) bool {
+	return n.kind == scalarNode && n.tag == "!!null"
+}
+
+func modMerge(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+
+	m, err := newMerger(mergeLists(arg))
+	if err != nil {
+		return ""
+	}
+	var merged *node
+	for i, item := range n.content {
+		merged = m.add(merged, item, i)
+	}
+	if merged == nil {
+		return ""
+	}
+	return merged.marshal()
+}
+
+// mergeLists returns the list strategies of the argument of @merge, which
+// is a mapping like MergeOptions.Lists.
+func mergeLists(arg string) map[string]string {
+	lists := make(map[string]string)
+	Parse(arg).ForEach(func(key, value Result) bool {
+		lists[key.String()] = value.String()
+		return true
+	})
+	return lists
+}
+
+// checkMergeArgument returns an error if the argument of @merge has an
+// unknown strategy.
+func checkMergeArgument(arg string) error {
+	_, err := newMerger(mergeLists(arg))
+	return err
+}
@@ -0,0 +1,177 @@
+package gyaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBaseYAML = `
+name: app
+replicas: 1
+debug: false
+labels:
+  tier: web
+  team: core
+containers:
+  - name: app
+    image: app:v1
+    env: [A]
+  - name: sidecar
+    image: proxy:v1
+args: [--port, "80"]
+`
+
+const testProdYAML = `
+replicas: 3
+labels:
+  team: null
+  env: prod
+containers:
+  - name: app
+    image: app:v2
+  - name: metrics
+    image: metrics:v1
+args: [--verbose]
+extra:
+  kept: 1
+  gone: null
+`
+
+func TestMerge(t *testing.T) {
+	merged, err := Merge(testBaseYAML, testProdYAML, "", "debug: true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"name", "app"},
+		{"replicas", "3"},
+		{"debug", "true"},
+		{"labels|@keys", "tier,env"},
+		{"labels.env", "prod"},
+		{"containers.#.image", "app:v2,metrics:v1"},
+		{"args", "--verbose"},
+		{"extra|@keys", "kept"},
+	}
+	for _, tt := range tests {
+		if got := values(Get(merged, tt.path)); got != tt.want {
+			t.Errorf("Get(merged, %q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+	if Get(merged, "labels.team").Exists() {
+		t.Error("Merge kept a key set to null")
+	}
+
+	if _, err := Merge(testBaseYAML, "a: [b"); err == nil {
+		t.Error("Merge accepted invalid yaml")
+	}
+	if merged, err := Merge("a: 1", "null", ""); err != nil || Get(merged, "a").Int() != 1 {
+		t.Errorf("Merge with empty documents = %q, %v", merged, err)
+	}
+	if merged, err := Merge("a: null", "b: null"); err != nil || !Get(merged, "a").IsNull() || Get(merged, "b").Exists() {
+		t.Errorf("Merge kept the wrong nulls: %q, %v", merged, err)
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	opts := MergeOptions{Lists: map[string]string{
+		"containers":       "merge:name",
+		"containers.#.env": "append",
+		"args":             "append",
+	}}
+	merged, layers, err := opts.Merge(testBaseYAML, testProdYAML, "containers: [{name: sidecar, env: [B]}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"containers.#.name", "app,sidecar,metrics"},
+		{"containers.#.image", "app:v2,proxy:v1,metrics:v1"},
+		{"containers.0.env", "A"},
+		{"containers.1.env", "B"},
+		{"args", "--port,80,--verbose"},
+	}
+	for _, tt := range tests {
+		if got := values(Get(merged, tt.path)); got != tt.want {
+			t.Errorf("Get(merged, %q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	want := map[string]int{
+		"name":               0,
+		"replicas":           1,
+		"debug":              0,
+		"labels.tier":        0,
+		"labels.env":         1,
+		"containers.0.name":  1,
+		"containers.0.image": 1,
+		"containers.0.env.0": 0,
+		"containers.1.name":  2,
+		"containers.1.image": 0,
+		"containers.1.env.0": 2,
+		"containers.2.name":  1,
+		"containers.2.image": 1,
+		"args.0":             0,
+		"args.1":             0,
+		"args.2":             1,
+		"extra.kept":         1,
+	}
+	if !reflect.DeepEqual(layers, want) {
+		t.Errorf("layers = %v, want %v", layers, want)
+	}
+
+	if _, _, err := (MergeOptions{Lists: map[string]string{"args": "prepend"}}).Merge("a: 1"); err == nil {
+		t.Error("Merge accepted an unknown strategy")
+	}
+}
+
+func TestMergeModifier(t *testing.T) {
+	yaml := `
+layers:
+  - {name: app, ports: [80], env: {A: 1, B: 2}}
+  - {ports: [443], env: {B: null, C: 3}}
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"layers|@merge.name", "app"},
+		{"layers|@merge.ports", "443"},
+		{`layers|@merge:{"ports":"append"}.ports`, "80,443"},
+		{"layers|@merge.env|@keys", "A,C"},
+		{"layers.0|@merge.name", "app"},
+	}
+	for _, tt := range tests {
+		if got := values(Get(yaml, tt.path)); got != tt.want {
+			t.Errorf("Get(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	path := `layers|@merge:{"ports":"bogus"}`
+	if res := Get(yaml, path); res.Exists() {
+		t.Errorf("Get(%q) = %q, want nothing", path, res.Raw)
+	}
+	if _, err := GetE(yaml, path); err == nil {
+		t.Errorf("GetE(%q) should fail", path)
+	} else if e, ok := err.(*ModifierError); !ok || e.Name != "merge" || !strings.Contains(e.Reason, "bogus") {
+		t.Errorf("GetE(%q) error = %v, want a *ModifierError", path, err)
+	}
+}
+
+// values joins the items of an array result, or returns the string of any
+// other result.
+func values(res Result) string {
+	if !res.IsArray() {
+		return res.String()
+	}
+	var items []string
+	for _, item := range res.Array() {
+		items = append(items, item.String())
+	}
+	return strings.Join(items, ",")
+}
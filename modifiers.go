@@ -0,0 +1,493 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortOptions are the options of the @sort modifier, which are read from an
+// argument like {"by":"age","desc":true}. An argument that is not an object
+// is the by path.
+type sortOptions struct {
+	by      string
+	desc    bool
+	nocase  bool
+	natural bool
+}
+
+func parseSortOptions(arg string) sortOptions {
+	arg = strings.TrimSpace(arg)
+	if !strings.HasPrefix(arg, "{") {
+		return sortOptions{by: unquoteArgument(arg)}
+	}
+	opts := Parse(arg)
+	return sortOptions{
+		by:      opts.Get("by").String(),
+		desc:    opts.Get("desc").Bool(),
+		nocase:  opts.Get("nocase").Bool(),
+		natural: opts.Get("natural").Bool(),
+	}
+}
+
+// valueAt returns the value at path under value, or value itself for an
+// empty path.
+func valueAt(value *node, path string) Result {
+	res := value.result()
+	if path != "" {
+		res = res.Get(path)
+	}
+	return res
+}
+
+// less reports whether the value a sorts before b, ignoring the direction.
+func (o sortOptions) less(a, b Result) bool {
+	if o.natural && a.Type == String && b.Type == String {
+		return naturalLess(a.Str, b.Str, !o.nocase)
+	}
+	return a.Less(b, !o.nocase)
+}
+
+func modSort(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil {
+		return yamlStr
+	}
+
+	opts := parseSortOptions(arg)
+
+	switch n.kind {
+	case sequenceNode:
+		items := n.items()
+		keys := make([]Result, len(items))
+		for i, item := range items {
+			keys[i] = valueAt(item, opts.by)
+		}
+		order := sortedOrder(keys, opts)
+		sorted := make([]*node, len(items))
+		for i, j := range order {
+			sorted[i] = items[j]
+		}
+		return newSequence(sorted).marshal()
+	case mappingNode:
+		// the members of a mapping are sorted by their values
+		var keys []Result
+		n.pairs(func(key, value *node) bool {
+			keys = append(keys, valueAt(value, opts.by))
+			return true
+		})
+		order := sortedOrder(keys, opts)
+		sorted := make([]*node, 0, len(n.content))
+		for _, j := range order {
+			sorted = append(sorted, n.content[2*j], n.content[2*j+1])
+		}
+		return newMapping(sorted).marshal()
+	}
+	return yamlStr
+}
+
+// sortedOrder returns the indexes of keys in sorted order. The sort is
+// stable, so that equal values keep their order in either direction.
+func sortedOrder(keys []Result, opts sortOptions) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if opts.desc {
+			a, b = b, a
+		}
+		return opts.less(a, b)
+	})
+	return order
+}
+
+// naturalLess compares two strings like less, except that runs of digits
+// are compared by their numeric values, so that "v2" comes before "v10".
+func naturalLess(a, b string, caseSensitive bool) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			x := strings.TrimLeft(a[si:i], "0")
+			y := strings.TrimLeft(b[sj:j], "0")
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			continue
+		}
+		ca, cb := a[i], b[j]
+		if !caseSensitive {
+			ca, cb = lowerASCII(ca), lowerASCII(cb)
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	// the strings only differ in leading zeros
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 32
+	}
+	return c
+}
+
+// modifierValues returns the values at path under the items of a sequence,
+// or under the values of a mapping, that an aggregation modifier computes
+// over. The values that don't exist are left out.
+func modifierValues(yamlStr, path string) ([]Result, bool) {
+	n := modifierInput(yamlStr)
+	if n == nil || (n.kind != sequenceNode && n.kind != mappingNode) {
+		return nil, false
+	}
+	path = unquoteArgument(path)
+	var values []Result
+	n.values(func(value *node) {
+		if res := valueAt(value, path); res.Exists() {
+			values = append(values, res)
+		}
+	})
+	return values, true
+}
+
+// number is a numeric value of an aggregation. An integer is kept exactly,
+// as its text may not fit a float64.
+type number struct {
+	exact *big.Int
+	num   float64
+	raw   string
+}
+
+// numbers returns the numeric values of values, skipping the others.
+func numbers(values []Result) []number {
+	var nums []number
+	for _, value := range values {
+		if value.Type != Number {
+			continue
+		}
+		// the text of the node, as the raw yaml of a value that is not in
+		// a source is formatted from its float
+		raw := strings.TrimSpace(value.Raw)
+		if value.node != nil && value.node.kind == scalarNode {
+			raw = value.node.value
+		}
+		num := number{num: value.Num, raw: raw}
+		if i, ok := new(big.Int).SetString(strings.ReplaceAll(raw, "_", ""), 0); ok {
+			num.exact = i
+		}
+		nums = append(nums, num)
+	}
+	return nums
+}
+
+// compare compares two numbers, exactly if they are both integers.
+func (a number) compare(b number) int {
+	if a.exact != nil && b.exact != nil {
+		return a.exact.Cmp(b.exact)
+	}
+	switch {
+	case a.num < b.num:
+		return -1
+	case a.num > b.num:
+		return 1
+	}
+	return 0
+}
+
+// sum returns the sum of nums, which is an integer when they all are.
+func sum(nums []number) (*big.Int, float64) {
+	total := new(big.Int)
+	var f float64
+	for _, num := range nums {
+		if total != nil && num.exact != nil {
+			total.Add(total, num.exact)
+			continue
+		}
+		if total != nil {
+			f, _ = new(big.Float).SetInt(total).Float64()
+			total = nil
+		}
+		f += num.num
+	}
+	return total, f
+}
+
+// formatFloat formats a float as a plain YAML number.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return ".nan"
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func modCount(yamlStr, arg string) string {
+	values, ok := modifierValues(yamlStr, arg)
+	if !ok {
+		return yamlStr
+	}
+	return strconv.Itoa(len(values))
+}
+
+func modSum(yamlStr, arg string) string {
+	values, ok := modifierValues(yamlStr, arg)
+	if !ok {
+		return yamlStr
+	}
+	total, f := sum(numbers(values))
+	if total != nil {
+		return total.String()
+	}
+	return formatFloat(f)
+}
+
+func modAvg(yamlStr, arg string) string {
+	values, ok := modifierValues(yamlStr, arg)
+	if !ok {
+		return yamlStr
+	}
+	nums := numbers(values)
+	if len(nums) == 0 {
+		return ""
+	}
+	total, f := sum(nums)
+	if total != nil {
+		avg := new(big.Rat).SetFrac(total, big.NewInt(int64(len(nums))))
+		if avg.IsInt() {
+			return avg.Num().String()
+		}
+		f, _ = avg.Float64()
+		return formatFloat(f)
+	}
+	return formatFloat(f / float64(len(nums)))
+}
+
+func modMin(yamlStr, arg string) string {
+	return extreme(yamlStr, arg, -1)
+}
+
+func modMax(yamlStr, arg string) string {
+	return extreme(yamlStr, arg, 1)
+}
+
+// extreme returns the first of the smallest numbers for a sign of -1, or
+// of the largest for 1, as it is written.
+func extreme(yamlStr, arg string, sign int) string {
+	values, ok := modifierValues(yamlStr, arg)
+	if !ok {
+		return yamlStr
+	}
+	nums := numbers(values)
+	if len(nums) == 0 {
+		return ""
+	}
+	best := nums[0]
+	for _, num := range nums[1:] {
+		if num.compare(best) == sign {
+			best = num
+		}
+	}
+	return best.raw
+}
+
+// groupKey returns a string that is the same for the values that are
+// equal, like the numbers 5 and 5.0, or mappings with the same members in
+// another order.
+func groupKey(value Result) string {
+	switch value.Type {
+	case Number:
+		num := numbers([]Result{value})[0]
+		if num.exact != nil {
+			return "n" + num.exact.String()
+		}
+		return "n" + formatFloat(num.num)
+	case String:
+		return "s" + value.Str
+	case YAML:
+		// encoding/json sorts the keys of mappings
+		if data, err := json.Marshal(value.tree().decode()); err == nil {
+			return "y" + string(data)
+		}
+		return "y" + value.Raw
+	}
+	return strconv.Itoa(int(value.Type))
+}
+
+// groups splits the items of a sequence by their values at path, in the
+// order in which the values first appear. Members keep their order in the
+// sequence, and the items without the value are left out.
+func groups(n *node, path string) (keys []*node, members [][]*node) {
+	path = unquoteArgument(path)
+	index := make(map[string]int)
+	for _, item := range n.items() {
+		value := valueAt(item, path)
+		if !value.Exists() || value.node == nil {
+			continue
+		}
+		key := groupKey(value)
+		i, ok := index[key]
+		if !ok {
+			i = len(keys)
+			index[key] = i
+			keys = append(keys, value.node)
+			members = append(members, nil)
+		}
+		members[i] = append(members[i], item)
+	}
+	return keys, members
+}
+
+func modGroupBy(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+	return groupBy(n, arg).marshal()
+}
+
+// groupBy returns the mapping of the groups of the items of a sequence, in
+// which the items are the nodes of the sequence, along with their
+// positions. Any other value is returned as it is.
+func groupBy(n *node, arg string) *node {
+	if n.kind != sequenceNode {
+		return n
+	}
+	keys, members := groups(n, arg)
+	content := make([]*node, 0, 2*len(keys))
+	for i, key := range keys {
+		content = append(content, key, newSequence(members[i]))
+	}
+	return newMapping(content)
+}
+
+func modCountBy(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+
+	keys, members := groups(n, arg)
+	content := make([]*node, 0, 2*len(keys))
+	for i, key := range keys {
+		content = append(content, key, newInt(len(members[i])))
+	}
+	return newMapping(content).marshal()
+}
+
+// distinct returns the items whose values at path are not equal to the
+// value of an item before them, or of an item in seen. The items without
+// the value are all kept. The keys of the items are added to seen.
+func distinct(items []*node, path string, seen map[string]bool) []*node {
+	var result []*node
+	for _, item := range items {
+		value := valueAt(item, path)
+		if !value.Exists() {
+			result = append(result, item)
+			continue
+		}
+		key := groupKey(value)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// setItems returns the items of the yaml of a set modifier argument. A
+// value that is not a sequence is a set of one item.
+func setItems(yamlStr string) []*node {
+	n := modifierInput(yamlStr)
+	switch {
+	case n == nil:
+		return nil
+	case n.kind == sequenceNode:
+		return n.items()
+	}
+	return []*node{n}
+}
+
+// keySet returns the keys of the values of items.
+func keySet(items []*node) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[groupKey(item.result())] = true
+	}
+	return set
+}
+
+func modUniq(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+	return newSequence(distinct(n.items(), unquoteArgument(arg), map[string]bool{})).marshal()
+}
+
+func modUnion(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+	seen := make(map[string]bool)
+	union := distinct(n.items(), "", seen)
+	union = append(union, distinct(setItems(arg), "", seen)...)
+	return newSequence(union).marshal()
+}
+
+func modIntersect(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+	other := keySet(setItems(arg))
+	var intersection []*node
+	for _, item := range distinct(n.items(), "", map[string]bool{}) {
+		if other[groupKey(item.result())] {
+			intersection = append(intersection, item)
+		}
+	}
+	return newSequence(intersection).marshal()
+}
+
+func modDifference(yamlStr, arg string) string {
+	n := modifierInput(yamlStr)
+	if n == nil || n.kind != sequenceNode {
+		return yamlStr
+	}
+	// the items of the argument are seen already
+	return newSequence(distinct(n.items(), "", keySet(setItems(arg)))).marshal()
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selector is one of the paths of a {...} or [...] multipath, with the key
+// of its value in the object built by a {...} multipath.
+type selector struct {
+	key string
+	// path is the path as it was written, which is evaluated as is when it
+	// starts with a modifier
+	path  string
+	parts []pathComponent
+}
+
+// parseMultipath parses the multipath whose opening bracket is at path[i],
+// and returns the offset of its closing bracket.
+func parseMultipath(path string, i int) (pathComponent, int, error) {
+	end := multipathEnd(path, i)
+	if end == -1 {
+		return pathComponent{}, 0, &QueryError{Segment: path[i:], Reason: "missing closing bracket"}
+	}
+	comp := pathComponent{isMultipath: true, object: path[i] == '{', segment: path[i : end+1]}
+	keys := make(map[string]bool)
+	for _, entry := range splitSelectors(path[i+1 : end]) {
+		sel, err := parseSelector(entry, comp.object)
+		if err != nil {
+			var queryErr *QueryError
+			if !errors.As(err, &queryErr) {
+				err = &QueryError{Segment: comp.segment, Reason: err.Error()}
+			}
+			return pathComponent{}, 0, err
+		}
+		if comp.object && keys[sel.key] {
+			// the keys of a mapping are unique
+			return pathComponent{}, 0, &QueryError{Segment: comp.segment, Reason: "duplicate key " + strconv.Quote(sel.key)}
+		}
+		keys[sel.key] = true
+		comp.selectors = append(comp.selectors, sel)
+	}
+	return comp, end, nil
+}
+
+// multipathEnd returns the offset of the bracket that closes the multipath
+// whose opening bracket is at i, or -1 if it is not closed.
+func multipathEnd(path string, i int) int {
+	depth := 0
+	for ; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"':
+			end := quotedEnd(path, i)
+			if end == len(path) {
+				return -1
+			}
+			i = end - 1
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitSelectors splits the content of a multipath at the commas that are
+// not nested in brackets, parentheses or strings.
+func splitSelectors(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var entries []string
+	start, depth := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			i = quotedEnd(s, i) - 1
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				entries = append(entries, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(entries, s[start:])
+}
+
+// parseSelector parses a path of a multipath, which is preceded by a quoted
+// key in an object, like "kids":children.
+func parseSelector(entry string, object bool) (selector, error) {
+	entry = strings.TrimSpace(entry)
+	var sel selector
+	if strings.HasPrefix(entry, `"`) {
+		end := quotedEnd(entry, 0)
+		rest := strings.TrimLeft(entry[end:], " ")
+		if !strings.HasPrefix(rest, ":") {
+			return selector{}, fmt.Errorf("missing colon after key %s", entry[:end])
+		}
+		if !object {
+			return selector{}, fmt.Errorf("unexpected key %s in an array", entry[:end])
+		}
+		key, err := strconv.Unquote(entry[:end])
+		if err != nil {
+			return selector{}, fmt.Errorf("invalid key %s", entry[:end])
+		}
+		sel.key = key
+		entry = strings.TrimSpace(rest[1:])
+	}
+	if entry == "" {
+		return selector{}, errors.New("empty path")
+	}
+	sel.path = entry
+	if entry[0] != '@' {
+		parts, err := parsePath(entry)
+		if err != nil {
+			return selector{}, err
+		}
+		sel.parts = parts
+		if n := len(parts); n > 1 && parts[n-1].hasPipe {
+			// a pipe is applied to the value of the path before it
+			parts = parts[:n-1]
+		}
+		if sel.key == "" && len(parts) > 0 && !parts[len(parts)-1].hasPipe {
+			// the value is named after the last key of its path
+			last := parts[len(parts)-1]
+			sel.key = last.segment
+			if last.isKey() || last.isIndex {
+				sel.key = last.key
+			}
+		}
+	}
+	if sel.key == "" {
+		sel.key = entry
+	}
+	return sel, nil
+}
+
+// multipath builds the object or array of a multipath from the values that
+// its paths find in data, which is at the location at. The paths that are
+// not found are left out.
+func multipath(data, root *node, part pathComponent, at location) (*node, error) {
+	var content []*node
+	var locs []location
+	for _, sel := range part.selectors {
+		var n *node
+		var loc location
+		var err error
+		if sel.parts == nil {
+			n, err = evalPath(data, root, sel.path, data.result().Raw)
+		} else {
+			n, loc, err = traverse(data, root, sel.parts, at)
+		}
+		if err != nil {
+			if isMissing(err) {
+				continue
+			}
+			return nil, err
+		}
+		if n == nil {
+			continue
+		}
+		if part.object {
+			content = append(content, &node{kind: scalarNode, tag: "!!str", value: sel.key})
+		}
+		content = append(content, n)
+		locs = append(locs, loc)
+	}
+	if part.object {
+		return withPaths(newMapping(content), locs), nil
+	}
+	return withPaths(newSequence(content), locs), nil
+}
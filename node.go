@@ -0,0 +1,726 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+type nodeKind uint8
+
+const (
+	scalarNode nodeKind = iota
+	mappingNode
+	sequenceNode
+)
+
+// node is a parsed YAML value. Aliases and merge keys are resolved while
+// the tree is built, so that every lookup sees the same values.
+type node struct {
+	kind nodeKind
+	// tag is the resolved short tag, such as !!str or !!int
+	tag string
+	// value is the value of a scalar
+	value string
+	// anchor is the anchor defined on the node
+	anchor string
+	// alias is the name of the alias the node was reached through
+	alias string
+	// content holds the items of a sequence, or the keys and values of a
+	// mapping one after the other
+	content []*node
+	// src is the text the node was parsed from, and index and end the byte
+	// offsets of the node in that text. Nodes that are not part of the
+	// original yaml, such as the output of a modifier, have no src.
+	src   *source
+	index int
+	end   int
+	// expanded is true when aliases or merge keys were resolved inside the
+	// node, so that its text can't be parsed on its own
+	expanded bool
+	// paths are the resolved paths of the items, or of the values, of a
+	// collection built from the matches of a path
+	paths []string
+	// weight is the number of nodes in the tree of the node, with its
+	// aliases expanded
+	weight int
+}
+
+// source is a text that nodes are parsed from. It may be a part of a larger
+// text, in which case offset, line and column locate it in that text.
+type source struct {
+	text   string
+	offset int
+	line   int
+	column int
+	once   sync.Once
+	lines  lineIndex
+}
+
+func newSource(text string) *source {
+	return &source{text: text, line: 1, column: 1}
+}
+
+func (s *source) lineIndex() lineIndex {
+	s.once.Do(func() {
+		s.lines = newLineIndex(s.text)
+	})
+	return s.lines
+}
+
+// position returns the byte offset, line and column in the larger text of
+// the byte at offset i of the source.
+func (s *source) position(i int) (index, line, column int) {
+	line, column = s.lineIndex().position(s.text, i)
+	return s.shift(i, line, column)
+}
+
+// scanPosition is like position, but counts the lines before offset i
+// rather than indexing every line of the source. It's used by the fast
+// path, which locates a single value.
+func (s *source) scanPosition(i int) (index, line, column int) {
+	ls := lineStart(s.text, i)
+	line = strings.Count(s.text[:ls], "\n") + 1
+	return s.shift(i, line, utf8.RuneCountInString(s.text[ls:i])+1)
+}
+
+// shift converts a position in the source to a position in the larger
+// text.
+func (s *source) shift(i, line, column int) (int, int, int) {
+	if line == 1 {
+		column += s.column - 1
+	}
+	return s.offset + i, line + s.line - 1, column
+}
+
+// lineIndex holds the byte offset of the start of every line of a text.
+type lineIndex []int
+
+func newLineIndex(text string) lineIndex {
+	lines := lineIndex{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// offset converts a 1-based line and rune column to a byte offset.
+func (lines lineIndex) offset(text string, line, column int) int {
+	if line < 1 || line > len(lines) {
+		return len(text)
+	}
+	i := lines[line-1]
+	for c := 1; c < column && i < len(text) && text[i] != '\n'; c++ {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return i
+}
+
+// position converts a byte offset to a 1-based line and rune column.
+func (lines lineIndex) position(text string, i int) (line, column int) {
+	l, h := 0, len(lines)
+	for l+1 < h {
+		m := (l + h) / 2
+		if lines[m] <= i {
+			l = m
+		} else {
+			h = m
+		}
+	}
+	return l + 1, utf8.RuneCountInString(text[lines[l]:i]) + 1
+}
+
+// skipProperties skips the anchor and tag that may precede the node at
+// offset i.
+func skipProperties(text string, i int) int {
+	for i < len(text) && (text[i] == '&' || text[i] == '!') {
+		for i < len(text) && !isSpace(text[i]) && text[i] != ',' {
+			i++
+		}
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+	}
+	return i
+}
+
+// anchorDef is an anchor found while building a tree.
+type anchorDef struct {
+	name string
+	path string
+	node *node
+}
+
+// maxExpansion is the number of nodes that the aliases of a document may
+// add to it, unless the document is large enough that they add less than
+// expansionRatio times its own nodes. A small document whose aliases
+// expand to a huge tree, like the billion laughs, is rejected, as yaml.v3
+// rejects it, since every lookup and walk of the tree would expand it.
+const (
+	maxExpansion   = 100000
+	expansionRatio = 10
+)
+
+type nodeBuilder struct {
+	built   map[*yamlv3.Node]*node
+	anchors []anchorDef
+	src     *source
+	// count is the number of nodes of the document, without the nodes
+	// that its aliases add
+	count int
+	// whole is true when the yaml is a single document, which nothing may
+	// follow, rather than the start of a stream
+	whole bool
+}
+
+// parseSource parses the first document of a source, recording the
+// position of every node.
+func parseSource(src *source) (*node, error) {
+	b := nodeBuilder{src: src}
+	return b.parse(src.text)
+}
+
+// parseStreamDocument parses a source holding one document of a stream,
+// which is invalid if more content follows the document.
+func parseStreamDocument(src *source) (*node, error) {
+	b := nodeBuilder{src: src, whole: true}
+	return b.parse(src.text)
+}
+
+// parseValue parses yaml that is not part of the original document, such
+// as the output of a modifier. Its nodes have no position.
+func parseValue(yaml string) (*node, error) {
+	var b nodeBuilder
+	return b.parse(yaml)
+}
+
+func (b *nodeBuilder) parse(yaml string) (*node, error) {
+	dec := yamlv3.NewDecoder(strings.NewReader(yaml))
+	var doc yamlv3.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if b.whole {
+		var next yamlv3.Node
+		if err := dec.Decode(&next); err != io.EOF {
+			if err == nil {
+				err = errors.New("yaml: content after the end of the document")
+			}
+			return nil, err
+		}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	b.built = make(map[*yamlv3.Node]*node)
+	root := b.build(doc.Content[0], "", -1, false)
+	if added := root.weight - b.count; added > maxExpansion && added > expansionRatio*b.count {
+		return nil, errors.New("yaml: document contains excessive aliasing")
+	}
+	return root, nil
+}
+
+// build converts a yaml.v3 node. The indent is the column of the block
+// collection holding the node, or -1 at the top of the document, and flow
+// is true inside a flow collection.
+func (b *nodeBuilder) build(yn *yamlv3.Node, path string, indent int, flow bool) *node {
+	if yn.Kind == yamlv3.AliasNode {
+		target, ok := b.built[yn.Alias]
+		if !ok {
+			target = b.build(yn.Alias, path, indent, flow)
+		}
+		c := *target
+		c.anchor = ""
+		c.alias = yn.Value
+		return &c
+	}
+	n := &node{tag: yn.ShortTag(), value: yn.Value, anchor: yn.Anchor}
+	if b.src != nil {
+		n.src = b.src
+		n.index = b.start(yn)
+	}
+	b.built[yn] = n
+	b.count++
+	if yn.Anchor != "" {
+		b.anchors = append(b.anchors, anchorDef{name: yn.Anchor, path: path, node: n})
+	}
+	switch yn.Kind {
+	case yamlv3.SequenceNode:
+		n.kind = sequenceNode
+		n.content = make([]*node, len(yn.Content))
+		flow, column := b.layout(yn, n, flow)
+		for i, c := range yn.Content {
+			n.content[i] = b.build(c, joinPath(path, strconv.Itoa(i)), column, flow)
+			n.expanded = n.expanded || c.Kind == yamlv3.AliasNode || n.content[i].expanded
+		}
+	case yamlv3.MappingNode:
+		n.kind = mappingNode
+		n.content = b.mapping(yn, n, path, flow)
+	}
+	n.weight = 1
+	for _, c := range n.content {
+		// the weight saturates rather than overflows
+		n.weight = min(n.weight+c.weight, math.MaxInt/2)
+	}
+	if b.src != nil {
+		n.end = b.end(yn, indent, flow)
+	}
+	return n
+}
+
+// start returns the offset of the content of a node, after its anchor and
+// tag.
+func (b *nodeBuilder) start(yn *yamlv3.Node) int {
+	text := b.src.text
+	i := b.src.lineIndex().offset(text, yn.Line, yn.Column)
+	j := skipProperties(text, i)
+	if yn.Kind == yamlv3.ScalarNode && strings.IndexByte(text[i:j], '\n') != -1 {
+		// an empty value with properties, like "key: !!null"
+		j = i + len(strings.TrimRight(text[i:j], " \t\r\n"))
+	}
+	return j
+}
+
+// layout returns whether the content of a collection is in flow style, and
+// the column of its entries.
+func (b *nodeBuilder) layout(yn *yamlv3.Node, n *node, flow bool) (bool, int) {
+	if b.src == nil {
+		return flow, 0
+	}
+	return flow || yn.Style&yamlv3.FlowStyle != 0, n.index - lineStart(b.src.text, n.index)
+}
+
+// end returns the offset just past the text of a node.
+func (b *nodeBuilder) end(yn *yamlv3.Node, indent int, flow bool) int {
+	text := b.src.text
+	switch yn.Kind {
+	case yamlv3.AliasNode:
+		return b.src.lineIndex().offset(text, yn.Line, yn.Column) + 1 + len(yn.Value)
+	case yamlv3.MappingNode, yamlv3.SequenceNode:
+		i := b.start(yn)
+		if yn.Style&yamlv3.FlowStyle != 0 {
+			return flowEnd(text, i)
+		}
+		if len(yn.Content) == 0 {
+			return i
+		}
+		return b.end(yn.Content[len(yn.Content)-1], i-lineStart(text, i), flow)
+	}
+	return scalarEnd(text, b.start(yn), indent, flow)
+}
+
+// mapping builds the content of a mapping. The pairs of "<<" merge keys
+// are inserted where the merge key appears. Keys defined in the mapping
+// itself take precedence over merged ones, and earlier merge sources over
+// later ones.
+func (b *nodeBuilder) mapping(yn *yamlv3.Node, n *node, path string, flow bool) []*node {
+	flow, column := b.layout(yn, n, flow)
+	defined := make(map[string]bool)
+	for i := 0; i+1 < len(yn.Content); i += 2 {
+		if !isMergeKey(yn.Content[i]) {
+			defined[yn.Content[i].Value] = true
+		}
+	}
+	content := make([]*node, 0, len(yn.Content))
+	for i := 0; i+1 < len(yn.Content); i += 2 {
+		k, v := yn.Content[i], yn.Content[i+1]
+		if !isMergeKey(k) {
+			value := b.build(v, joinPath(path, escapeComponent(k.Value)), column, flow)
+			content = append(content, b.build(k, path, column, flow), value)
+			n.expanded = n.expanded || v.Kind == yamlv3.AliasNode || value.expanded
+			continue
+		}
+		n.expanded = true
+		var sources []*node
+		if v.Kind == yamlv3.SequenceNode {
+			for _, c := range v.Content {
+				sources = append(sources, b.build(c, path, column, flow))
+			}
+		} else {
+			sources = append(sources, b.build(v, path, column, flow))
+		}
+		for _, src := range sources {
+			if src.kind != mappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(src.content); j += 2 {
+				key := src.content[j]
+				if defined[key.value] {
+					continue
+				}
+				defined[key.value] = true
+				content = append(content, src.via(key), src.via(src.content[j+1]))
+			}
+		}
+	}
+	return content
+}
+
+func isMergeKey(n *yamlv3.Node) bool {
+	return n.Kind == yamlv3.ScalarNode && n.Value == "<<" && n.ShortTag() == "!!merge"
+}
+
+func joinPath(path, component string) string {
+	if path == "" {
+		return component
+	}
+	return path + "." + component
+}
+
+// via returns the child c as seen from n. Everything under a node that was
+// reached through an alias is reached through that alias too.
+func (n *node) via(c *node) *node {
+	if n.alias == "" || c.alias != "" {
+		return c
+	}
+	cp := *c
+	cp.alias = n.alias
+	return &cp
+}
+
+// items returns the items of a sequence.
+func (n *node) items() []*node {
+	if n.kind != sequenceNode {
+		return nil
+	}
+	if n.alias == "" {
+		return n.content
+	}
+	items := make([]*node, len(n.content))
+	for i, c := range n.content {
+		items[i] = n.via(c)
+	}
+	return items
+}
+
+// pairs calls the iterator for every key and value of a mapping.
+func (n *node) pairs(iterator func(key, value *node) bool) {
+	if n.kind != mappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.content); i += 2 {
+		if !iterator(n.via(n.content[i]), n.via(n.content[i+1])) {
+			return
+		}
+	}
+}
+
+// lookup returns the value of a mapping key, or nil.
+func (n *node) lookup(key string) *node {
+	if n.kind != mappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.content); i += 2 {
+		if n.content[i].value == key && n.content[i].kind == scalarNode {
+			return n.via(n.content[i+1])
+		}
+	}
+	return nil
+}
+
+// describe returns the kind of value of the node, for errors.
+func (n *node) describe() string {
+	switch n.kind {
+	case mappingNode:
+		return "a mapping"
+	case sequenceNode:
+		return "a sequence"
+	}
+	switch n.tag {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "a boolean"
+	case "!!int", "!!float":
+		return "a number"
+	}
+	return "a string"
+}
+
+// size returns the number of items or pairs of a collection.
+func (n *node) size() int {
+	switch n.kind {
+	case sequenceNode:
+		return len(n.content)
+	case mappingNode:
+		return len(n.content) / 2
+	}
+	return 0
+}
+
+func newSequence(items []*node) *node {
+	return &node{kind: sequenceNode, tag: "!!seq", content: items}
+}
+
+func newMapping(content []*node) *node {
+	return &node{kind: mappingNode, tag: "!!map", content: content}
+}
+
+func newInt(i int) *node {
+	return &node{kind: scalarNode, tag: "!!int", value: strconv.Itoa(i)}
+}
+
+// decode converts the node to the Go types used by yaml.v3 when decoding
+// into an interface{}.
+func (n *node) decode() interface{} {
+	if n == nil {
+		return nil
+	}
+	switch n.kind {
+	case sequenceNode:
+		items := n.items()
+		v := make([]interface{}, len(items))
+		for i, item := range items {
+			v[i] = item.decode()
+		}
+		return v
+	case mappingNode:
+		v := make(map[string]interface{}, n.size())
+		n.pairs(func(key, value *node) bool {
+			v[key.value] = value.decode()
+			return true
+		})
+		return v
+	}
+	return scalarValue(n.tag, n.value)
+}
+
+// decodeOrdered is like decode, but decodes mappings to a MapSlice that
+// keeps the order of their keys.
+func (n *node) decodeOrdered() interface{} {
+	if n == nil {
+		return nil
+	}
+	switch n.kind {
+	case sequenceNode:
+		items := n.items()
+		v := make([]interface{}, len(items))
+		for i, item := range items {
+			v[i] = item.decodeOrdered()
+		}
+		return v
+	case mappingNode:
+		v := make(MapSlice, 0, n.size())
+		n.pairs(func(key, value *node) bool {
+			v = append(v, MapItem{Key: key.value, Value: value.decodeOrdered()})
+			return true
+		})
+		return v
+	}
+	return scalarValue(n.tag, n.value)
+}
+
+// encode converts the node to a yaml.v3 node, keeping the order of the
+// keys of mappings.
+func (n *node) encode() *yamlv3.Node {
+	switch n.kind {
+	case sequenceNode:
+		yn := &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		for _, item := range n.items() {
+			yn.Content = append(yn.Content, item.encode())
+		}
+		return yn
+	case mappingNode:
+		yn := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		n.pairs(func(key, value *node) bool {
+			yn.Content = append(yn.Content, key.encode(), value.encode())
+			return true
+		})
+		return yn
+	}
+	return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: n.tag, Value: n.value}
+}
+
+// marshal encodes the node to YAML.
+func (n *node) marshal() string {
+	data, _ := yamlv3.Marshal(n.encode())
+	return string(data)
+}
+
+// isBigInt returns true if value is written as a decimal integer.
+func isBigInt(value string) bool {
+	_, ok := new(big.Int).SetString(value, 10)
+	return ok
+}
+
+// scalarValue resolves a scalar the way yaml.v3 does.
+func scalarValue(tag, value string) interface{} {
+	switch tag {
+	case "!!null":
+		return nil
+	case "!!bool":
+		return value == "true" || value == "True" || value == "TRUE"
+	case "!!int":
+		plain := strings.ReplaceAll(value, "_", "")
+		if i, err := strconv.ParseInt(plain, 0, 64); err == nil {
+			if i == int64(int(i)) {
+				return int(i)
+			}
+			return i
+		}
+		if f, err := strconv.ParseFloat(plain, 64); err == nil {
+			return f
+		}
+	case "!!float":
+		switch strings.ToLower(strings.TrimPrefix(value, "+")) {
+		case ".inf":
+			return math.Inf(1)
+		case "-.inf":
+			return math.Inf(-1)
+		case ".nan":
+			return math.NaN()
+		}
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// result converts the node to a Result. The Raw of a node of the
+// original yaml is a substring of that yaml.
+func (n *node) result() Result {
+	if n == nil {
+		return Result{}
+	}
+	var res Result
+	start := n.index
+	switch {
+	case n.kind == scalarNode && n.src == nil:
+		res = valueToResult(scalarValue(n.tag, n.value))
+		if res.Type == Number && n.tag == "!!float" && isBigInt(n.value) {
+			// keep the digits of an integer that doesn't fit an int64
+			res.Raw = n.value
+		}
+	case n.src == nil || n.expanded:
+		res = Result{Type: YAML, Raw: n.marshal()}
+	case n.kind == scalarNode:
+		res = scalarResult(n.tag, n.value, n.src.text[n.index:n.end])
+	default:
+		res.Type = YAML
+		res.Raw, start = collectionRaw(n.src.text, n.index, n.end)
+	}
+	res.Alias = n.alias
+	res.node = n
+	if n.src != nil {
+		res.Index, _, _ = n.src.position(start)
+		_, res.Line, res.Column = n.src.position(n.index)
+	}
+	if n.paths != nil {
+		res.Paths = n.paths
+		res.Indexes = make([]int, 0, len(n.paths))
+		n.values(func(value *node) {
+			res.Indexes = append(res.Indexes, value.offset())
+		})
+	}
+	return res
+}
+
+// offset returns the Index of the result of a node, or 0 if the node is not
+// in the original yaml.
+func (n *node) offset() int {
+	if n.src == nil {
+		return 0
+	}
+	start := n.index
+	if n.kind != scalarNode && !n.expanded {
+		start = collectionStart(n.src.text, n.index)
+	}
+	return n.src.offset + start
+}
+
+// values calls fn for the items of a sequence, or the values of a mapping.
+func (n *node) values(fn func(value *node)) {
+	if n.kind == sequenceNode {
+		for _, item := range n.items() {
+			fn(item)
+		}
+		return
+	}
+	n.pairs(func(key, value *node) bool {
+		fn(value)
+		return true
+	})
+}
+
+// keyResult converts a mapping key to a Result.
+func (n *node) keyResult() Result {
+	res := Result{Type: String, Str: n.value, Raw: n.value}
+	n.locate(&res)
+	return res
+}
+
+// locate sets the position of the node on a result.
+func (n *node) locate(res *Result) {
+	if n.src != nil {
+		res.Index, res.Line, res.Column = n.src.position(n.index)
+	}
+}
+
+// hasAnchors returns true if the yaml defines anchors or uses aliases or
+// merge keys, which the fast path does not resolve.
+func hasAnchors(yaml string) bool {
+	if strings.Contains(yaml, "<<") {
+		return true
+	}
+	for i := 0; i < len(yaml); i++ {
+		if (yaml[i] != '&' && yaml[i] != '*') || i+1 == len(yaml) || isSpace(yaml[i+1]) {
+			continue
+		}
+		// an anchor or alias starts a node, after an indicator or at the
+		// start of a line
+		j := i - 1
+		for j >= 0 && (yaml[j] == ' ' || yaml[j] == '\t') {
+			j--
+		}
+		if j < 0 || strings.IndexByte("\n:-,[{?", yaml[j]) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Anchor is an anchor defined in a YAML document.
+type Anchor struct {
+	// Name is the name of the anchor, without the '&'
+	Name string
+	// Path is the path of the anchored value
+	Path string
+	// Value is the anchored value
+	Value Result
+}
+
+// Anchors returns the anchors defined in the first document of yaml, in
+// the order they appear.
+//
+//	for _, a := range gyaml.Anchors(yaml) {
+//		println(a.Name, a.Path)
+//	}
+func Anchors(yaml string) []Anchor {
+	b := nodeBuilder{src: newSource(yaml)}
+	if _, err := b.parse(yaml); err != nil {
+		return nil
+	}
+	anchors := make([]Anchor, len(b.anchors))
+	for i, def := range b.anchors {
+		anchors[i] = Anchor{Name: def.name, Path: def.path, Value: def.node.result()}
+	}
+	return anchors
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Path is a compiled path, which can be searched for many times without
+// being parsed again. A Path is safe for concurrent use by multiple
+// goroutines.
+type Path struct {
+	// text is the path as it was given
+	text string
+	// path is the path without its leading dot, or without the ".." prefix
+	// of a document stream path
+	path string
+	// stream is true for a path with the ".." prefix
+	stream bool
+	// keys are the components of a path that the fast parser can search,
+	// which is nil for other paths
+	keys []string
+
+	once  sync.Once
+	parts []pathComponent
+	err   error
+}
+
+// Compile parses a path. A *QueryError is returned when a query of the path
+// is malformed.
+//
+//	p, err := gyaml.Compile("friends.#(age>45)#.first")
+//	if err != nil {
+//		return err
+//	}
+//	for _, doc := range docs {
+//		println(p.Get(doc).String())
+//	}
+func Compile(path string) (*Path, error) {
+	p := newPath(path)
+	if _, err := p.components(); err != nil {
+		return nil, withPath(err, path)
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile but panics if the path is malformed. It
+// simplifies the initialization of global variables holding paths.
+func MustCompile(path string) *Path {
+	p, err := Compile(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// newPath prepares a path for searching. The components used by the slow
+// path are only parsed when they are needed.
+func newPath(path string) *Path {
+	p := &Path{text: path, path: path}
+	if len(path) > 1 && path[0] == '.' && path[1] == '.' {
+		p.stream = true
+		p.path = path[2:]
+		return p
+	}
+	if len(path) > 0 && path[0] == '.' {
+		// path starts with dot, remove it
+		p.path = path[1:]
+	}
+	if p.path != "" && !hasComplexFeatures(p.path) {
+		p.keys = splitPath(p.path)
+	}
+	return p
+}
+
+// String returns the path as it was compiled.
+func (p *Path) String() string {
+	return p.text
+}
+
+// Get searches yaml for the path. It's the same as gyaml.Get(yaml, path).
+func (p *Path) Get(yaml string) Result {
+	res, _ := get(newSource(yaml), p, false)
+	return res
+}
+
+// GetBytes searches yaml for the path.
+// If working with bytes, this method preferred over p.Get(string(data)).
+func (p *Path) GetBytes(yaml []byte) Result {
+	return p.Get(*(*string)(unsafe.Pointer(&yaml)))
+}
+
+// GetE searches yaml for the path. It's the same as gyaml.GetE(yaml, path).
+func (p *Path) GetE(yaml string) (Result, error) {
+	res, err := get(newSource(yaml), p, true)
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// GetPath searches result for a compiled path.
+// The result should be a YAML array or object.
+func (t Result) GetPath(p *Path) Result {
+	if t.node != nil && !p.stream {
+		n, at, err := p.eval(t.node, t.Raw)
+		if err != nil {
+			return Result{}
+		}
+		res := n.result()
+		res.at = t.at.join(at)
+		return res
+	}
+	res, _ := get(t.source(), p, false)
+	res.at = t.at.join(res.at)
+	return res
+}
+
+// components returns the parsed components of the path, which is nil for
+// a path that is empty or starts with a modifier.
+func (p *Path) components() ([]pathComponent, error) {
+	p.once.Do(func() {
+		if p.path != "" && p.path[0] != '@' {
+			p.parts, p.err = parsePath(p.path)
+		}
+	})
+	return p.parts, p.err
+}
+
+// eval returns the node found at the path, and its location relative to
+// data.
+func (p *Path) eval(data *node, origYAML string) (*node, location, error) {
+	parts, err := p.components()
+	if err != nil {
+		return nil, location{}, err
+	}
+	if len(parts) == 0 {
+		n, err := evalPath(data, data, p.path, origYAML)
+		if p.path == "" || p.path == "@this" {
+			return n, location{known: true}, err
+		}
+		return n, location{}, err
+	}
+	return traverse(data, data, parts, location{known: true})
+}
@@ -0,0 +1,481 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// queryExpr is a parsed #(...) query expression. The grammar, from the
+// lowest precedence to the highest, is:
+//
+//	or         = and { "||" and }
+//	and        = not { "&&" not }
+//	not        = "!" not | "(" or ")" | comparison
+//	comparison = path [ operator value ]
+//
+// A comparison without an operator is true when the path exists. The
+// @key path is the key of an item of a mapping, or the index of an item of
+// a sequence.
+type queryExpr interface {
+	match(item *node, key queryKey) bool
+}
+
+// queryKey is the key of a value of a mapping, or the index of an item of
+// a sequence, that a query looks at.
+type queryKey struct {
+	key   *node
+	index int
+}
+
+func (k queryKey) node() *node {
+	if k.key != nil {
+		return k.key
+	}
+	return newInt(k.index)
+}
+
+type orExpr struct {
+	left, right queryExpr
+}
+
+func (e orExpr) match(item *node, key queryKey) bool {
+	return e.left.match(item, key) || e.right.match(item, key)
+}
+
+type andExpr struct {
+	left, right queryExpr
+}
+
+func (e andExpr) match(item *node, key queryKey) bool {
+	return e.left.match(item, key) && e.right.match(item, key)
+}
+
+type notExpr struct {
+	expr queryExpr
+}
+
+func (e notExpr) match(item *node, key queryKey) bool {
+	return !e.expr.match(item, key)
+}
+
+// queryCondition compares the value at a path of an item, or the item
+// itself for an empty path, to a value.
+type queryCondition struct {
+	path  string
+	parts []pathComponent
+	// op is the comparison operator, which is empty for a condition that
+	// only checks that the path exists
+	op    string
+	value *node
+	// re is the compiled pattern of a =~ or !~ condition
+	re *regexp.Regexp
+}
+
+// queryOperators are the comparison operators, longest first.
+var queryOperators = []string{"==", "!=", "<=", ">=", "!%", "=~", "!~", "<", ">", "%"}
+
+// parseQuery parses the expression of a #(...) query.
+func parseQuery(query string) (queryExpr, error) {
+	p := queryParser{query: query}
+	p.space()
+	if p.done() {
+		return nil, errors.New("empty query")
+	}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.space(); !p.done() {
+		return nil, fmt.Errorf("unexpected %q", p.query[p.i:])
+	}
+	return expr, nil
+}
+
+type queryParser struct {
+	query string
+	i     int
+}
+
+func (p *queryParser) done() bool {
+	return p.i >= len(p.query)
+}
+
+func (p *queryParser) space() {
+	for !p.done() && isSpace(p.query[p.i]) {
+		p.i++
+	}
+}
+
+// consume skips s if the query continues with it.
+func (p *queryParser) consume(s string) bool {
+	p.space()
+	if strings.HasPrefix(p.query[p.i:], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) or() (queryExpr, error) {
+	left, err := p.and()
+	for err == nil && p.consume("||") {
+		var right queryExpr
+		if right, err = p.and(); err == nil {
+			left = orExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) and() (queryExpr, error) {
+	left, err := p.not()
+	for err == nil && p.consume("&&") {
+		var right queryExpr
+		if right, err = p.not(); err == nil {
+			left = andExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) not() (queryExpr, error) {
+	p.space()
+	if p.done() {
+		return nil, errors.New("unexpected end of query")
+	}
+	switch p.query[p.i] {
+	case '!':
+		if p.operator() != "" {
+			// a comparison of the item itself, like !="dog"
+			break
+		}
+		p.i++
+		expr, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case '(':
+		p.i++
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return expr, nil
+	}
+	return p.comparison()
+}
+
+func (p *queryParser) comparison() (queryExpr, error) {
+	cond := &queryCondition{path: p.path()}
+	if cond.path != "" && cond.path[0] != '@' {
+		parts, err := parsePath(cond.path)
+		if err != nil {
+			return nil, err
+		}
+		cond.parts = parts
+	}
+	p.space()
+	cond.op = p.operator()
+	if cond.op == "" {
+		if cond.path == "" {
+			if p.done() {
+				return nil, errors.New("unexpected end of query")
+			}
+			return nil, fmt.Errorf("unexpected %q", p.query[p.i:])
+		}
+		return cond, nil
+	}
+	p.i += len(cond.op)
+	p.space()
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("no value after %q", cond.op)
+	}
+	cond.value = value
+	if cond.op == "=~" || cond.op == "!~" {
+		if value.kind != scalarNode {
+			return nil, fmt.Errorf("invalid pattern after %q", cond.op)
+		}
+		if cond.re, err = compileRegexp(value.value); err != nil {
+			return nil, err
+		}
+	}
+	return cond, nil
+}
+
+// operator returns the comparison operator at the current position.
+func (p *queryParser) operator() string {
+	for _, op := range queryOperators {
+		if strings.HasPrefix(p.query[p.i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// path scans the path of a comparison, which ends before an operator,
+// whitespace, a closing parenthesis or a boolean operator. Nested queries
+// and escaped characters are part of the path.
+func (p *queryParser) path() string {
+	start, depth := p.i, 0
+	for ; !p.done(); p.i++ {
+		c := p.query[p.i]
+		switch {
+		case c == '\\':
+			p.i++
+		case c == '"' || c == '\'':
+			if depth > 0 {
+				p.i = quotedEnd(p.query, p.i) - 1
+			}
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return p.query[start:p.i]
+			}
+			depth--
+		case depth > 0:
+		case isSpace(c), strings.IndexByte("=!<>%", c) != -1,
+			strings.HasPrefix(p.query[p.i:], "&&"), strings.HasPrefix(p.query[p.i:], "||"):
+			return p.query[start:p.i]
+		}
+	}
+	if p.i > len(p.query) {
+		p.i = len(p.query)
+	}
+	return p.query[start:p.i]
+}
+
+// value scans the value of a comparison, which is a typed literal: a
+// quoted string, a flow sequence or mapping, or a plain word that is
+// resolved like a plain YAML scalar, so that 5 is a number, true a boolean
+// and null is null. A word ends before whitespace, a closing parenthesis or
+// a boolean operator. It returns nil if there is no value.
+func (p *queryParser) value() (*node, error) {
+	if p.done() {
+		return nil, nil
+	}
+	switch q := p.query[p.i]; q {
+	case '"', '\'':
+		end := quotedEnd(p.query, p.i)
+		if end == len(p.query) && (end-p.i < 2 || p.query[end-1] != q) {
+			return nil, errors.New("unterminated string")
+		}
+		raw := p.query[p.i:end]
+		p.i = end
+		value := raw[1 : len(raw)-1]
+		if q == '"' {
+			if s, err := strconv.Unquote(raw); err == nil {
+				value = s
+			}
+		}
+		return &node{kind: scalarNode, tag: "!!str", value: value}, nil
+	case '[', '{':
+		end := flowEnd(p.query, p.i)
+		raw := p.query[p.i:end]
+		if c := raw[len(raw)-1]; c != ']' && c != '}' {
+			return nil, errors.New("unterminated flow collection")
+		}
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", raw)
+		}
+		p.i = end
+		return value, nil
+	}
+	start := p.i
+	for !p.done() && !isSpace(p.query[p.i]) && p.query[p.i] != ')' &&
+		!strings.HasPrefix(p.query[p.i:], "&&") && !strings.HasPrefix(p.query[p.i:], "||") {
+		p.i++
+	}
+	if start == p.i {
+		return nil, nil
+	}
+	word := p.query[start:p.i]
+	tag := resolvePlain(word)
+	if tag == "!!merge" {
+		tag = "!!str"
+	}
+	return &node{kind: scalarNode, tag: tag, value: word}, nil
+}
+
+// operand returns the value that the condition looks at, or nil if it
+// doesn't exist in the item.
+func (cond *queryCondition) operand(item *node, key queryKey) *node {
+	var n *node
+	var err error
+	switch {
+	case cond.path == "":
+		return item
+	case cond.path == "@key":
+		return key.node()
+	case cond.parts == nil:
+		n, err = evalPath(item, item, cond.path, item.result().Raw)
+	default:
+		n, err = traversePath(item, item, cond.parts)
+	}
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+// match returns true if the item satisfies the condition. A condition on a
+// path that doesn't exist in the item is false.
+func (cond *queryCondition) match(item *node, key queryKey) bool {
+	value := cond.operand(item, key)
+	if value == nil {
+		return false
+	}
+	if cond.op == "" {
+		return true
+	}
+	if cond.re != nil {
+		// patterns match the text of scalars
+		matched := value.kind == scalarNode && cond.re.MatchString(value.value)
+		return matched == (cond.op == "=~")
+	}
+	return compareValues(value, cond.op, cond.value)
+}
+
+// compareValues applies a comparison operator to the value of an item and
+// the literal of a query.
+func compareValues(value *node, op string, literal *node) bool {
+	switch op {
+	case "%", "!%":
+		// patterns match the text of scalars
+		matched := value.kind == scalarNode && literal.kind == scalarNode &&
+			matchPattern(value.value, literal.value)
+		return matched == (op == "%")
+	}
+	c := compareNodes(value, literal)
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareNodes compares two values in the type order of Result.Less, which
+// is null, false, numbers, strings, true, and then collections. Numbers are
+// compared by value, so 5 and 5.0 are equal, and collections are equal when
+// their contents are.
+func compareNodes(a, b *node) int {
+	ra, rb := typedValue(a), typedValue(b)
+	if ra.Type != rb.Type {
+		if ra.Type < rb.Type {
+			return -1
+		}
+		return 1
+	}
+	switch ra.Type {
+	case Number:
+		if a.tag == "!!int" && b.tag == "!!int" {
+			// compare integers exactly, they may not fit a float64
+			x, xerr := strconv.ParseInt(strings.ReplaceAll(a.value, "_", ""), 0, 64)
+			y, yerr := strconv.ParseInt(strings.ReplaceAll(b.value, "_", ""), 0, 64)
+			if xerr == nil && yerr == nil {
+				return cmp.Compare(x, y)
+			}
+		}
+		return cmp.Compare(ra.Num, rb.Num)
+	case String:
+		return strings.Compare(ra.Str, rb.Str)
+	case YAML:
+		if reflect.DeepEqual(a.decode(), b.decode()) {
+			return 0
+		}
+		return strings.Compare(a.marshal(), b.marshal())
+	}
+	return 0
+}
+
+// typedValue returns the type, and the value of a scalar, of a node.
+func typedValue(n *node) Result {
+	if n.kind != scalarNode {
+		return Result{Type: YAML}
+	}
+	return scalarResult(n.tag, n.value, n.value)
+}
+
+// regexpCache holds the compiled patterns of queries and of @match, as the
+// same pattern is usually matched against many documents.
+var regexpCache struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}
+
+// maxCachedRegexps limits the size of the regexp cache, which is emptied
+// when it is full.
+const maxCachedRegexps = 1024
+
+// compileRegexp compiles a regular expression, or returns it from the cache.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if re, ok := regexpCache.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if regexpCache.m == nil || len(regexpCache.m) >= maxCachedRegexps {
+		regexpCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
+
+// queryEnd returns the offset of the parenthesis that closes the query
+// whose opening parenthesis is at i, or -1 if it is not closed.
+func queryEnd(path string, i int) int {
+	depth := 0
+	for ; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"', '\'':
+			end := quotedEnd(path, i)
+			if end == len(path) {
+				return -1
+			}
+			i = end - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,999 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// The fast path finds the value at a simple path by scanning the yaml in a
+// single pass, the way gjson scans JSON, without building a tree. Only the
+// entries on the way to the value are looked at, and the value is returned
+// as a substring of the yaml. Documents using a feature the scanner does
+// not handle, such as aliases, tags or complex keys, are left to the slow
+// path, which builds a tree with yaml.v3.
+
+// scanned is a value found by the scanner.
+type scanned struct {
+	kind nodeKind
+	// style is the indicator that starts a quoted scalar, a block scalar or
+	// a flow collection, and zero for plain scalars and block collections
+	style byte
+	// start and end are the byte offsets of the value. The end of a block
+	// collection is only found when it is needed, and is -1 until then.
+	start int
+	end   int
+	// indent is the column of the entries of a block collection
+	indent int
+}
+
+func (v scanned) isBlock() bool {
+	return v.kind != scalarNode && v.style == 0
+}
+
+// scanner scans the first document of a yaml stream.
+type scanner struct {
+	text string
+	// ends caches the ends of block collections by their starts, as the
+	// end of a collection holding multiline values is scanned for every
+	// collection holding it
+	ends map[int]int
+}
+
+// fastGet searches the first document of src for a path without complex
+// features, as split by splitPath. False is returned when the path has to
+// be searched by the slow path, because the value was not found or because
+// the document uses something the scanner does not handle.
+func fastGet(src *source, keys []string) (Result, bool) {
+	if len(keys) == 0 {
+		return Result{}, false
+	}
+	doc := firstDocument(src.text)
+	s := scanner{text: src.text[:doc.end]}
+	if hasAnchors(s.text[doc.start:]) {
+		return Result{}, false
+	}
+	i := s.skip(doc.start)
+	if i == len(s.text) {
+		return Result{}, false
+	}
+	v, ok := s.value(i, -1)
+	at := location{known: true}
+	for n, key := range keys {
+		if !ok {
+			return Result{}, false
+		}
+		if key == "#" {
+			if n < len(keys)-1 || v.kind == scalarNode {
+				// "#" followed by more keys projects over the items
+				return Result{}, false
+			}
+			count, ok := s.count(v)
+			if !ok {
+				return Result{}, false
+			}
+			return Result{Type: Number, Num: float64(count), Raw: strconv.Itoa(count)}, true
+		}
+		if index, err := strconv.Atoi(key); err == nil && index < 0 && v.kind == sequenceNode {
+			// a negative index counts from the end of the sequence
+			count, ok := s.count(v)
+			if !ok || count+index < 0 {
+				return Result{}, false
+			}
+			key = strconv.Itoa(count + index)
+		}
+		v, ok = s.child(v, key)
+		at = at.child(escapeComponent(key))
+	}
+	if !ok {
+		return Result{}, false
+	}
+	res, ok := s.result(src, v)
+	res.at = at
+	return res, ok
+}
+
+// count returns the number of entries of a collection.
+func (s *scanner) count(v scanned) (int, bool) {
+	count := 0
+	ok := s.each(v, func(string, scanned) bool { count++; return true })
+	return count, ok
+}
+
+// result converts a scanned value to a Result.
+func (s *scanner) result(src *source, v scanned) (Result, bool) {
+	if v.isBlock() {
+		end, ok := s.blockEnd(v)
+		if !ok {
+			return Result{}, false
+		}
+		v.end = end
+	}
+	var res Result
+	start := v.start
+	if v.kind == scalarNode {
+		var ok bool
+		if res, ok = s.scalar(v); !ok {
+			return Result{}, false
+		}
+	} else {
+		res.Type = YAML
+		res.Raw, start = collectionRaw(s.text, v.start, v.end)
+	}
+	res.Index, _, _ = src.scanPosition(start)
+	_, res.Line, res.Column = src.scanPosition(v.start)
+	return res, true
+}
+
+// scalar decodes a scanned scalar. Plain scalars and quoted scalars
+// without escapes on a single line are decoded here, and the rest by
+// yaml.v3.
+func (s *scanner) scalar(v scanned) (Result, bool) {
+	raw := s.text[v.start:v.end]
+	multiline := strings.IndexByte(raw, '\n') != -1
+	switch {
+	case v.style == 0 && !multiline:
+		return scalarResult(resolvePlain(raw), raw, raw), true
+	case v.style == '\'' && !multiline:
+		return scalarResult("!!str", strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), raw), true
+	case v.style == '"' && !multiline && strings.IndexByte(raw, '\\') == -1:
+		return scalarResult("!!str", raw[1:len(raw)-1], raw), true
+	}
+	text := raw
+	if v.style == '|' || v.style == '>' {
+		// the chomping of a block scalar depends on the line breaks
+		// following its content
+		end := v.end
+		for end < len(s.text) && isSpace(s.text[end]) {
+			end++
+		}
+		text = s.text[v.start:max(lineStart(s.text, end), v.end)]
+		if end == len(s.text) {
+			text = s.text[v.start:]
+		}
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(text), &doc); err != nil || len(doc.Content) == 0 {
+		return Result{}, false
+	}
+	n := doc.Content[0]
+	if n.Kind != yamlv3.ScalarNode {
+		return Result{}, false
+	}
+	return scalarResult(n.ShortTag(), n.Value, raw), true
+}
+
+// child returns the value of a key of a mapping, or of an index of a
+// sequence.
+func (s *scanner) child(v scanned, key string) (scanned, bool) {
+	var found scanned
+	var ok bool
+	switch v.kind {
+	case mappingNode:
+		if !s.each(v, func(k string, val scanned) bool {
+			if k == key {
+				found, ok = val, true
+			}
+			return !ok
+		}) {
+			return scanned{}, false
+		}
+	case sequenceNode:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 {
+			// other keys project over the items, which is left to the
+			// slow path
+			return scanned{}, false
+		}
+		if !s.each(v, func(_ string, val scanned) bool {
+			if index == 0 {
+				found, ok = val, true
+			}
+			index--
+			return !ok
+		}) {
+			return scanned{}, false
+		}
+	}
+	return found, ok
+}
+
+// each calls fn for every entry of a collection, with the key of mapping
+// entries, until fn returns false. It returns false if the collection
+// holds something the scanner does not handle.
+func (s *scanner) each(v scanned, fn func(key string, val scanned) bool) bool {
+	switch {
+	case v.kind == scalarNode:
+		return true
+	case v.style != 0:
+		return s.flowEach(v, fn)
+	case v.kind == mappingNode:
+		return s.mappingEach(v, fn)
+	}
+	return s.sequenceEach(v, fn)
+}
+
+// blockEnd returns the end of a block collection, which is the end of the
+// value of its last entry.
+func (s *scanner) blockEnd(v scanned) (int, bool) {
+	if end, ok := s.ends[v.start]; ok {
+		return end, true
+	}
+	var last scanned
+	if !s.each(v, func(_ string, val scanned) bool { last = val; return true }) {
+		return 0, false
+	}
+	end, ok := last.end, true
+	if last.isBlock() {
+		end, ok = s.blockEnd(last)
+	}
+	if ok {
+		if s.ends == nil {
+			s.ends = make(map[int]int)
+		}
+		s.ends[v.start] = end
+	}
+	return end, ok
+}
+
+// value scans the value that starts at i. The indent is the column of the
+// block collection holding the value, or -1 at the top of the document.
+func (s *scanner) value(i, indent int) (scanned, bool) {
+	text := s.text
+	if i >= len(text) {
+		return scanned{}, false
+	}
+	switch c := text[i]; c {
+	case '|', '>':
+		return s.blockScalar(i, indent)
+	case '[', '{':
+		end := flowEnd(text, i)
+		if (end == len(text) && text[end-1] != ']' && text[end-1] != '}') || isKey(text, end) {
+			return scanned{}, false
+		}
+		kind := sequenceNode
+		if c == '{' {
+			kind = mappingNode
+		}
+		return scanned{kind: kind, style: c, start: i, end: end}, true
+	case '"', '\'':
+		end, ok := quotedScalarEnd(text, i)
+		if !ok {
+			return scanned{}, false
+		}
+		if isKey(text, end) {
+			return s.blockMapping(i)
+		}
+		return scanned{kind: scalarNode, style: c, start: i, end: end}, true
+	case '-', '?', ':':
+		if i+1 == len(text) || isSpace(text[i+1]) {
+			if c == '-' {
+				return scanned{kind: sequenceNode, start: i, end: -1, indent: s.column(i)}, true
+			}
+			// complex keys and empty keys
+			return scanned{}, false
+		}
+	case '&', '!', '*', '%', '@', '`', ',', ']', '}', '#':
+		// anchors, tags, aliases and indicators that can't start a value
+		return scanned{}, false
+	}
+	if isKey(text, plainEnd(text, i, false)) {
+		return s.blockMapping(i)
+	}
+	return scanned{kind: scalarNode, start: i, end: plainScalarEnd(text, i, indent)}, true
+}
+
+func (s *scanner) blockMapping(i int) (scanned, bool) {
+	return scanned{kind: mappingNode, start: i, end: -1, indent: s.column(i)}, true
+}
+
+// blockScalar scans a literal or folded scalar whose header starts at i.
+func (s *scanner) blockScalar(i, indent int) (scanned, bool) {
+	header := s.text[i:plainEnd(s.text, i, false)]
+	if strings.ContainsAny(header, "123456789") {
+		// an explicit indentation, which is left to yaml.v3
+		return scanned{}, false
+	}
+	return scanned{kind: scalarNode, style: s.text[i], start: i, end: blockScalarEnd(s.text, i, indent)}, true
+}
+
+// column returns the column of offset i, counted in bytes from 0.
+func (s *scanner) column(i int) int {
+	return i - lineStart(s.text, i)
+}
+
+// skip skips whitespace, line breaks and comments.
+func (s *scanner) skip(i int) int {
+	text := s.text
+	for i < len(text) {
+		switch text[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		case '#':
+			i = lineEnd(text, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// key decodes the key of a mapping entry that starts at i, and returns
+// the offset just past its ':' indicator.
+func (s *scanner) key(i int) (string, int, bool) {
+	text := s.text
+	var key string
+	var end int
+	switch text[i] {
+	case '"', '\'':
+		var closed bool
+		if end, closed = quotedScalarEnd(text, i); !closed {
+			return "", 0, false
+		}
+		raw := text[i:end]
+		if strings.IndexByte(raw, '\n') != -1 || (text[i] == '"' && strings.IndexByte(raw, '\\') != -1) {
+			return "", 0, false
+		}
+		key = raw[1 : len(raw)-1]
+		if text[i] == '\'' {
+			key = strings.ReplaceAll(key, "''", "'")
+		}
+	case '[', '{', '?', '!', '&', '*', '|', '>', '-', ':', '#', '%', '@', '`', ',', ']', '}':
+		return "", 0, false
+	default:
+		end = plainEnd(text, i, false)
+		key = text[i:end]
+		if key == "<<" {
+			return "", 0, false
+		}
+	}
+	for end < len(text) && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+	if end == len(text) || text[end] != ':' {
+		return "", 0, false
+	}
+	return key, end + 1, true
+}
+
+// entryValue scans the value of a mapping entry or sequence item whose
+// indicator ends at i. The column is the column of the entry.
+func (s *scanner) entryValue(i, column int, mapping bool) (scanned, bool) {
+	text := s.text
+	j := i
+	for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
+		j++
+	}
+	if j < len(text) && text[j] != '\n' && text[j] != '\r' && text[j] != '#' {
+		// the value follows on the same line
+		v, ok := s.value(j, column)
+		if ok && mapping && v.isBlock() && lineStart(text, v.start) == lineStart(text, i) {
+			// a block collection can't start on the line of a key
+			return scanned{}, false
+		}
+		return v, ok
+	}
+	k := s.skip(j)
+	if k < len(text) {
+		c := s.column(k)
+		if c > column || (mapping && c == column && text[k] == '-' && (k+1 == len(text) || isSpace(text[k+1]))) {
+			return s.value(k, column)
+		}
+	}
+	// an empty value, positioned just after the indicator as yaml.v3 does
+	return scanned{kind: scalarNode, start: i, end: i}, true
+}
+
+// nextEntry returns the start of the entry that follows the entry whose
+// line starts at i in a block collection of the given column, or -1 if it
+// was the last, and the offset where the lines of the entry end. The
+// lines of the entry are recognized by their indentation.
+func (s *scanner) nextEntry(i, column int, sequence bool) (next, stop int, ok bool) {
+	text := s.text
+	for i = lineEnd(text, i); i < len(text); i = lineEnd(text, i) {
+		i++
+		j := i
+		for j < len(text) && text[j] == ' ' {
+			j++
+		}
+		if j == len(text) {
+			break
+		}
+		if c := text[j]; c == '\n' || c == '\r' || c == '#' || j-i > column {
+			continue
+		}
+		if j-i < column {
+			return -1, i, true
+		}
+		if text[j] == '\t' {
+			return 0, i, false
+		}
+		if dash := text[j] == '-' && (j+1 == len(text) || isSpace(text[j+1])); dash != sequence {
+			if sequence {
+				// a key following a sequence that is the value of an entry
+				return -1, i, true
+			}
+			// the items of a sequence that is the value of the entry
+			continue
+		}
+		return j, i, true
+	}
+	return -1, len(text), true
+}
+
+// entryAfter returns the start of the entry that follows an entry whose
+// value is val in a block collection of the given column, or -1 if it was
+// the last.
+func (s *scanner) entryAfter(val scanned, column int, sequence bool) (int, bool) {
+	// the entry ends where its value does, or with its line for a block
+	// collection or an empty value
+	last := val.start
+	if !val.isBlock() && val.end > last {
+		last = val.end - 1
+	}
+	next, stop, ok := s.nextEntry(last, column, sequence)
+	if ok && val.isBlock() && strings.ContainsAny(s.text[val.start:stop], "[{\"'") {
+		// a flow collection or a quoted scalar may go on with lines that
+		// are not indented, so the end of the collection is scanned
+		end, ok := s.blockEnd(val)
+		if !ok {
+			return 0, false
+		}
+		next, _, ok = s.nextEntry(max(end-1, val.start), column, sequence)
+		return next, ok
+	}
+	return next, ok
+}
+
+// mappingEach iterates through the entries of a block mapping.
+func (s *scanner) mappingEach(v scanned, fn func(key string, val scanned) bool) bool {
+	for i := v.start; i != -1; {
+		key, j, ok := s.key(i)
+		if !ok {
+			return false
+		}
+		val, ok := s.entryValue(j, v.indent, true)
+		if !ok {
+			return false
+		}
+		if !fn(key, val) {
+			return true
+		}
+		if i, ok = s.entryAfter(val, v.indent, false); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sequenceEach iterates through the items of a block sequence.
+func (s *scanner) sequenceEach(v scanned, fn func(key string, val scanned) bool) bool {
+	for i := v.start; i != -1; {
+		if s.text[i] != '-' || (i+1 < len(s.text) && !isSpace(s.text[i+1])) {
+			return false
+		}
+		val, ok := s.entryValue(i+1, v.indent, false)
+		if !ok {
+			return false
+		}
+		if !fn("", val) {
+			return true
+		}
+		if i, ok = s.entryAfter(val, v.indent, true); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// flowEach iterates through the entries of a flow collection.
+func (s *scanner) flowEach(v scanned, fn func(key string, val scanned) bool) bool {
+	text := s.text[:v.end]
+	closing := byte(']')
+	if v.style == '{' {
+		closing = '}'
+	}
+	for i := v.start + 1; ; {
+		i = s.skip(i)
+		if i >= len(text) {
+			return false
+		}
+		if text[i] == closing {
+			return true
+		}
+		var key string
+		var val scanned
+		var ok bool
+		if v.kind == mappingNode {
+			var k scanned
+			if k, ok = s.flowValue(text, i); !ok || k.kind != scalarNode {
+				return false
+			}
+			if key, ok = s.flowKey(k); !ok {
+				return false
+			}
+			i = s.skip(k.end)
+			if i < len(text) && text[i] == ':' {
+				if val, ok = s.flowValue(text, s.skip(i+1)); !ok {
+					return false
+				}
+			} else {
+				// a key without a value
+				val = scanned{kind: scalarNode, start: i, end: i}
+			}
+		} else {
+			if val, ok = s.flowValue(text, i); !ok {
+				return false
+			}
+			if j := s.skip(val.end); j < len(text) && text[j] == ':' {
+				// a single pair mapping, which is left to yaml.v3
+				return false
+			}
+		}
+		if !fn(key, val) {
+			return true
+		}
+		i = s.skip(val.end)
+		if i < len(text) && text[i] == ',' {
+			i++
+		} else if i >= len(text) || text[i] != closing {
+			return false
+		}
+	}
+}
+
+// flowValue scans a value inside a flow collection that ends at the end of
+// text.
+func (s *scanner) flowValue(text string, i int) (scanned, bool) {
+	if i >= len(text) {
+		return scanned{}, false
+	}
+	switch c := text[i]; c {
+	case '[', '{':
+		kind := sequenceNode
+		if c == '{' {
+			kind = mappingNode
+		}
+		end := flowEnd(text, i)
+		if text[end-1] != ']' && text[end-1] != '}' {
+			return scanned{}, false
+		}
+		return scanned{kind: kind, style: c, start: i, end: end}, true
+	case '"', '\'':
+		end, ok := quotedScalarEnd(text, i)
+		return scanned{kind: scalarNode, style: c, start: i, end: end}, ok
+	case '&', '!', '*', '|', '>', '%', '@', '`', ',', ']', '}', '#', '?', ':':
+		return scanned{}, false
+	case '-':
+		if i+1 < len(text) && isSpace(text[i+1]) {
+			return scanned{}, false
+		}
+	}
+	return scanned{kind: scalarNode, start: i, end: flowPlainEnd(text, i)}, true
+}
+
+// flowKey decodes the key of a flow mapping entry.
+func (s *scanner) flowKey(k scanned) (string, bool) {
+	res, ok := s.scalar(k)
+	if !ok {
+		return "", false
+	}
+	if res.Type == String {
+		return res.Str, true
+	}
+	return res.Raw, true
+}
+
+// collectionRaw returns the text of the collection between start and end,
+// and the offset where that text starts. A block collection starts at the
+// start of its line, so that all of its entries keep their indentation.
+// Indicators before it on that line, like the dash of a sequence item, are
+// replaced by spaces in a copy of the text.
+func collectionRaw(text string, start, end int) (string, int) {
+	ls := collectionStart(text, start)
+	if ls == start {
+		return text[start:end], start
+	}
+	prefix := text[ls:start]
+	if strings.Trim(prefix, " ") == "" {
+		return text[ls:end], ls
+	}
+	return strings.Repeat(" ", utf8.RuneCountInString(prefix)) + text[start:end], ls
+}
+
+// collectionStart returns the offset where the text of the collection at
+// start begins, which is the start of the line for a block collection.
+func collectionStart(text string, start int) int {
+	if start >= len(text) || text[start] == '[' || text[start] == '{' {
+		return start
+	}
+	return lineStart(text, start)
+}
+
+// scalarResult converts a resolved scalar to a Result holding raw.
+func scalarResult(tag, value, raw string) Result {
+	res := Result{Raw: raw}
+	switch v := scalarValue(tag, value).(type) {
+	case nil:
+		res.Type = Null
+		res.present = true
+	case bool:
+		res.Type = False
+		if v {
+			res.Type = True
+		}
+	case int:
+		res.Type = Number
+		res.Num = float64(v)
+	case int64:
+		res.Type = Number
+		res.Num = float64(v)
+	case float64:
+		res.Type = Number
+		res.Num = v
+	default:
+		res.Type = String
+		res.Str = value
+	}
+	return res
+}
+
+// resolvePlain returns the tag that yaml.v3 resolves a plain scalar to.
+func resolvePlain(value string) string {
+	switch value {
+	case "", "~", "null", "Null", "NULL":
+		return "!!null"
+	case "true", "True", "TRUE", "false", "False", "FALSE":
+		return "!!bool"
+	case ".nan", ".NaN", ".NAN", ".inf", ".Inf", ".INF",
+		"+.inf", "+.Inf", "+.INF", "-.inf", "-.Inf", "-.INF":
+		return "!!float"
+	case "<<":
+		return "!!merge"
+	}
+	c := value[0]
+	if c != '-' && c != '+' && c != '.' && (c < '0' || c > '9') {
+		return "!!str"
+	}
+	if c == '.' {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return "!!float"
+		}
+		return "!!str"
+	}
+	plain := strings.ReplaceAll(value, "_", "")
+	if _, err := strconv.ParseInt(plain, 0, 64); err == nil {
+		return "!!int"
+	}
+	if _, err := strconv.ParseUint(plain, 0, 64); err == nil {
+		return "!!int"
+	}
+	if isFloat(plain) {
+		return "!!float"
+	}
+	return "!!str"
+}
+
+// isFloat matches the floats of YAML 1.2, [-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?
+func isFloat(s string) bool {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+	if i < len(s) && s[i] == '.' {
+		j := digits(i + 1)
+		if j == i+1 {
+			return false
+		}
+		i = j
+	} else {
+		j := digits(i)
+		if j == i {
+			return false
+		}
+		i = j
+		if i < len(s) && s[i] == '.' {
+			i = digits(i + 1)
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+		j := digits(i)
+		if j == i {
+			return false
+		}
+		i = j
+	}
+	return i == len(s)
+}
+
+// hasComplexFeatures checks if the path requires the slow path
+func hasComplexFeatures(path string) bool {
+	// Check for features that need slow path:
+	// - Wildcards: *, ?
+	// - Queries: #(...)
+	// - Modifiers: @...
+	// - Pipes: |
+	// - Slices: 1:4
+	// - Recursive descents: **, ..
+	// - Multipaths: {...}, [...]
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '*', '?', '@', '|', ':', '{', '[':
+			return true
+		case '.':
+			if i+1 < len(path) && path[i+1] == '.' {
+				return true
+			}
+		case '#':
+			if i+1 < len(path) && path[i+1] == '(' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitPath splits a path by dots, handling escapes
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	var parts []string
+	var current strings.Builder
+	escaped := false
+
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if escaped {
+			current.WriteByte(ch)
+			escaped = false
+			continue
+		}
+		if ch == '\\' {
+			escaped = true
+			continue
+		}
+		if ch == '.' {
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteByte(ch)
+	}
+
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	return parts
+}
+
+// The functions below find the end of the text of a value. They are shared
+// by the scanner, the tree builder and the editor.
+
+func lineStart(text string, i int) int {
+	return strings.LastIndexByte(text[:i], '\n') + 1
+}
+
+func lineEnd(text string, i int) int {
+	if j := strings.IndexByte(text[i:], '\n'); j != -1 {
+		return i + j
+	}
+	return len(text)
+}
+
+// isKey returns true if a mapping indicator follows the scalar that ends
+// at i.
+func isKey(text string, i int) bool {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return i < len(text) && text[i] == ':' && (i+1 == len(text) || isSpace(text[i+1]))
+}
+
+// scalarEnd returns the end of the scalar that starts at i. The indent is
+// the column of the block collection holding the scalar, or -1 at the top
+// of the document, and flow is true inside a flow collection.
+func scalarEnd(text string, i, indent int, flow bool) int {
+	if i >= len(text) {
+		return i
+	}
+	switch text[i] {
+	case '"', '\'':
+		return quotedEnd(text, i)
+	case '|', '>':
+		if !flow {
+			return blockScalarEnd(text, i, indent)
+		}
+	case ' ', '\t', '\r', '\n', '#':
+		// an empty value
+		return i
+	}
+	if flow {
+		return flowPlainEnd(text, i)
+	}
+	return plainScalarEnd(text, i, indent)
+}
+
+// plainEnd scans the line of a plain scalar that starts at i.
+func plainEnd(text string, i int, flow bool) int {
+	start := i
+	for i < len(text) {
+		c := text[i]
+		if c == '\n' || c == '\r' {
+			break
+		}
+		if c == '#' && i > start && (text[i-1] == ' ' || text[i-1] == '\t') {
+			break
+		}
+		if c == ':' && (i+1 == len(text) || isSpace(text[i+1]) || (flow && strings.IndexByte(",[]{}", text[i+1]) != -1)) {
+			break
+		}
+		if flow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		i++
+	}
+	for i > start && (text[i-1] == ' ' || text[i-1] == '\t') {
+		i--
+	}
+	return i
+}
+
+// plainScalarEnd scans a plain scalar that starts at i in a block
+// collection of the given indent. The scalar continues on the following
+// lines that are indented more than the collection.
+func plainScalarEnd(text string, i, indent int) int {
+	end := plainEnd(text, i, false)
+	for {
+		j := end
+		for j < len(text) && (text[j] == ' ' || text[j] == '\t' || text[j] == '\r') {
+			j++
+		}
+		if j == len(text) || text[j] != '\n' {
+			// a comment or the end of a key
+			return end
+		}
+		// find the next line that is not blank
+		ls := j + 1
+		k := ls
+		for k < len(text) {
+			for k < len(text) && (text[k] == ' ' || text[k] == '\t') {
+				k++
+			}
+			if k == len(text) || (text[k] != '\n' && text[k] != '\r') {
+				break
+			}
+			ls = lineEnd(text, k) + 1
+			k = ls
+		}
+		if k >= len(text) || k-ls <= indent || text[k] == '#' || isDocumentMarker(text[ls:lineEnd(text, ls)]) {
+			return end
+		}
+		next := plainEnd(text, k, false)
+		if isKey(text, next) {
+			return end
+		}
+		end = next
+	}
+}
+
+// flowPlainEnd scans a plain scalar that starts at i inside a flow
+// collection, which may continue on the following lines.
+func flowPlainEnd(text string, i int) int {
+	end := plainEnd(text, i, true)
+	for {
+		j := end
+		for j < len(text) && (text[j] == ' ' || text[j] == '\t' || text[j] == '\r') {
+			j++
+		}
+		if j == len(text) || text[j] != '\n' {
+			return end
+		}
+		for j < len(text) && isSpace(text[j]) {
+			j++
+		}
+		if j == len(text) || strings.IndexByte(",[]{}#:", text[j]) != -1 {
+			return end
+		}
+		next := plainEnd(text, j, true)
+		if next < len(text) && text[next] == ':' {
+			return end
+		}
+		end = next
+	}
+}
+
+// quotedEnd scans a single or double quoted scalar that starts at i.
+func quotedEnd(text string, i int) int {
+	end, _ := quotedScalarEnd(text, i)
+	return end
+}
+
+// quotedScalarEnd is like quotedEnd, and also returns false when the
+// scalar is not closed.
+func quotedScalarEnd(text string, i int) (int, bool) {
+	q := text[i]
+	for i++; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if q == '"' {
+				i++
+			}
+		case q:
+			if q == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return len(text), false
+}
+
+// flowEnd scans a flow mapping or sequence that starts at i.
+func flowEnd(text string, i int) int {
+	depth := 0
+	for ; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\'':
+			i = quotedEnd(text, i) - 1
+		case '#':
+			if i > 0 && isSpace(text[i-1]) {
+				i = lineEnd(text, i) - 1
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(text)
+}
+
+// blockScalarEnd scans a literal or folded scalar whose header starts at
+// i, in a block collection of the given indent. The content ends at the
+// first line indented less than the first content line, or not more than
+// the collection.
+func blockScalarEnd(text string, i, indent int) int {
+	end := plainEnd(text, i, false)
+	first := -1
+	for j := lineEnd(text, i) + 1; j < len(text); {
+		k := lineEnd(text, j)
+		line := text[j:k]
+		if strings.TrimSpace(line) != "" {
+			n := indentOf(line)
+			if first == -1 {
+				first = n
+			}
+			if n < first || n <= indent || isDocumentMarker(line) {
+				break
+			}
+			end = j + len(strings.TrimRight(line, " \t\r"))
+		}
+		j = k + 1
+	}
+	return end
+}
@@ -0,0 +1,725 @@
+// Copyright 2024 GYAML Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gyaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Set sets a yaml value for the specified path.
+// A path is in dot syntax, such as "name.last" or "age". Missing mappings
+// along the path are created, and an index equal to the length of a
+// sequence appends a new element.
+//
+// The document is edited in place: comments, key order, quoting and every
+// line that is not part of the edited value are left untouched.
+//
+//	yaml, _ := gyaml.Set(yaml, "name.last", "Anderson")
+//	yaml, _ := gyaml.Set(yaml, "children.3", "Tom")
+func Set(yaml, path string, value interface{}) (string, error) {
+	frag, err := valueFragment(value)
+	if err != nil {
+		return yaml, err
+	}
+	return set(yaml, path, frag)
+}
+
+// SetBytes sets a yaml value for the specified path.
+// If working with bytes, this method preferred over Set(string(data), path, value)
+func SetBytes(yaml []byte, path string, value interface{}) ([]byte, error) {
+	res, err := Set(string(yaml), path, value)
+	if err != nil {
+		return yaml, err
+	}
+	return []byte(res), nil
+}
+
+// SetRaw sets a raw yaml value for the specified path.
+// The raw value must be valid YAML. It is inserted as written, re-indented
+// to fit its new position when it spans several lines.
+//
+//	yaml, _ := gyaml.SetRaw(yaml, "name", "{first: Tom, last: Anderson}")
+func SetRaw(yaml, path, value string) (string, error) {
+	frag, err := rawFragment(value)
+	if err != nil {
+		return yaml, err
+	}
+	return set(yaml, path, frag)
+}
+
+// SetRawBytes sets a raw yaml value for the specified path.
+// If working with bytes, this method preferred over SetRaw(string(data), path, value)
+func SetRawBytes(yaml []byte, path string, value []byte) ([]byte, error) {
+	res, err := SetRaw(string(yaml), path, string(value))
+	if err != nil {
+		return yaml, err
+	}
+	return []byte(res), nil
+}
+
+// Delete deletes a value from yaml for the specified path.
+// Deleting a path that does not exist returns the yaml unchanged.
+//
+//	yaml, _ := gyaml.Delete(yaml, "name.last")
+func Delete(yaml, path string) (string, error) {
+	parts, err := editPath(path)
+	if err != nil {
+		return yaml, err
+	}
+	e, err := newEditor(yaml)
+	if err != nil {
+		return yaml, err
+	}
+	t, err := e.find(parts)
+	if err != nil {
+		return yaml, withPath(err, path)
+	}
+	if t.node == nil || len(t.rest) > 0 {
+		return yaml, nil
+	}
+	return e.delete(t), nil
+}
+
+// DeleteBytes deletes a value from yaml for the specified path.
+// If working with bytes, this method preferred over Delete(string(data), path)
+func DeleteBytes(yaml []byte, path string) ([]byte, error) {
+	res, err := Delete(string(yaml), path)
+	if err != nil {
+		return yaml, err
+	}
+	return []byte(res), nil
+}
+
+func set(yaml, path string, frag fragment) (string, error) {
+	parts, err := editPath(path)
+	if err != nil {
+		return yaml, err
+	}
+	e, err := newEditor(yaml)
+	if err != nil {
+		return yaml, err
+	}
+	t, err := e.find(parts)
+	if err != nil {
+		return yaml, withPath(err, path)
+	}
+	res, err := e.set(t, frag)
+	if err != nil {
+		return yaml, withPath(err, path)
+	}
+	return res, nil
+}
+
+// editPath parses a path for Set and Delete, which only accept plain keys
+// and indexes.
+func editPath(path string) ([]pathComponent, error) {
+	parts, err := parsePath(path)
+	if err != nil {
+		return nil, withPath(err, path)
+	}
+	if len(parts) == 0 {
+		return nil, &PathError{Path: path, Reason: "path is empty"}
+	}
+	for _, part := range parts {
+		// a slice is a key, unless it's applied to a sequence
+		if part.isWild || part.isQuery || part.isCount || part.hasPipe ||
+			part.isDescent || part.isMultipath {
+			return nil, &PathError{Path: path, Segment: part.segment, Reason: "only keys and indexes can be edited"}
+		}
+	}
+	return parts, nil
+}
+
+// fragment is a value that is about to be written into a document.
+type fragment struct {
+	// text is the value rendered as a top level YAML document, without a
+	// trailing newline.
+	text string
+	// block is true when text is a block mapping or sequence, which has to
+	// start on its own line.
+	block bool
+	// node is the parsed value, used to render it in flow style.
+	node *yamlv3.Node
+}
+
+func valueFragment(value interface{}) (fragment, error) {
+	var n yamlv3.Node
+	if err := n.Encode(value); err != nil {
+		return fragment{}, fmt.Errorf("gyaml: cannot encode value: %w", err)
+	}
+	text, err := encodeNode(&n)
+	if err != nil {
+		return fragment{}, err
+	}
+	return fragment{text: text, block: isBlockCollection(&n), node: &n}, nil
+}
+
+func rawFragment(raw string) (fragment, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(raw), &doc); err != nil {
+		return fragment{}, &SyntaxError{Err: err}
+	}
+	if len(doc.Content) == 0 {
+		return fragment{}, &SyntaxError{Err: errors.New("empty value")}
+	}
+	n := doc.Content[0]
+	return fragment{text: dedent(raw), block: isBlockCollection(n), node: n}, nil
+}
+
+func encodeNode(n *yamlv3.Node) (string, error) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+func isBlockCollection(n *yamlv3.Node) bool {
+	return (n.Kind == yamlv3.MappingNode || n.Kind == yamlv3.SequenceNode) &&
+		n.Style&yamlv3.FlowStyle == 0 && len(n.Content) > 0
+}
+
+// flow renders the fragment on a single line, for use inside a flow
+// collection.
+func (f fragment) flow() string {
+	if !f.block && !strings.Contains(f.text, "\n") {
+		return f.text
+	}
+	n := flowNode(f.node)
+	text, err := encodeNode(n)
+	if err != nil {
+		return f.text
+	}
+	return text
+}
+
+// flowNode returns a copy of n with every collection in flow style and
+// literal scalars double quoted.
+func flowNode(n *yamlv3.Node) *yamlv3.Node {
+	c := *n
+	c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+	switch c.Kind {
+	case yamlv3.MappingNode, yamlv3.SequenceNode:
+		c.Style = yamlv3.FlowStyle
+	case yamlv3.ScalarNode:
+		if c.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0 {
+			c.Style = yamlv3.DoubleQuotedStyle
+		}
+	}
+	c.Content = make([]*yamlv3.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = flowNode(child)
+	}
+	return &c
+}
+
+// wrap nests the fragment under the remaining path components, creating
+// the mappings and sequences that do not exist yet.
+func (f fragment) wrap(parts []pathComponent) (fragment, error) {
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := parts[i]
+		if part.isIndex && part.key != strconv.Itoa(part.index) {
+			// keep keys such as "01" as mapping keys
+			part.isIndex = false
+		}
+		if part.isIndex {
+			if part.index != 0 {
+				return fragment{}, &IndexError{Segment: part.segment, Index: part.index}
+			}
+			f = fragment{
+				text:  "- " + placeInline(f, 2),
+				block: true,
+				node:  &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq", Content: []*yamlv3.Node{f.node}},
+			}
+			continue
+		}
+		key := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: part.key}
+		f = fragment{
+			text:  renderKey(part.key) + ":" + placeValue(f, 0, 2),
+			block: true,
+			node:  &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map", Content: []*yamlv3.Node{key, f.node}},
+		}
+	}
+	return f, nil
+}
+
+// placeValue renders the fragment as the value of a block mapping key
+// indented by indent spaces. The returned text follows the colon.
+func placeValue(f fragment, indent, childIndent int) string {
+	if f.block {
+		return "\n" + indentLines(f.text, childIndent, true)
+	}
+	return " " + placeInline(f, indent)
+}
+
+// placeInline renders the fragment so that its first line continues the
+// current line and the rest are indented by indent spaces.
+func placeInline(f fragment, indent int) string {
+	if idx := strings.IndexByte(f.text, '\n'); idx != -1 {
+		return f.text[:idx+1] + indentLines(f.text[idx+1:], indent, true)
+	}
+	return f.text
+}
+
+func renderKey(key string) string {
+	var n yamlv3.Node
+	n.Encode(key)
+	if n.Kind == yamlv3.ScalarNode && n.Tag == "!!str" && strings.IndexByte(key, '\n') == -1 {
+		text, err := encodeNode(&n)
+		if err == nil && !strings.HasPrefix(text, "|") && !strings.HasPrefix(text, ">") {
+			return text
+		}
+	}
+	return strconv.Quote(key)
+}
+
+func indentLines(text string, indent int, first bool) string {
+	prefix := strings.Repeat(" ", indent)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if (i > 0 || first) && line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dedent strips the common indentation and the surrounding blank lines
+// from text.
+func dedent(text string) string {
+	lines := strings.Split(strings.TrimRight(text, " \t\r\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	min := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := indentOf(line); min == -1 || n < min {
+			min = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= min && min > 0 {
+			lines[i] = line[min:]
+		} else {
+			lines[i] = strings.TrimLeft(line, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func indentOf(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+	return n
+}
+
+// editor edits the first document of a YAML stream by splicing text at
+// the positions reported by the yaml.v3 parser.
+type editor struct {
+	src   string
+	lines lineIndex
+	root  *yamlv3.Node
+	end   int // end of the first document
+}
+
+func newEditor(src string) (*editor, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(src), &doc); err != nil {
+		return nil, &SyntaxError{Err: err}
+	}
+	e := &editor{src: src, lines: newLineIndex(src)}
+	e.end = len(src)
+	if len(doc.Content) > 0 {
+		e.root = doc.Content[0]
+		if docs := splitDocuments(src); len(docs) > 0 {
+			e.end = docs[0].end
+		}
+	}
+	return e, nil
+}
+
+// offset converts a 1-based line and rune column to a byte offset.
+func (e *editor) offset(line, column int) int {
+	return e.lines.offset(e.src, line, column)
+}
+
+func (e *editor) start(n *yamlv3.Node) int {
+	return e.offset(n.Line, n.Column)
+}
+
+// contentStart skips the anchor and tag that may precede a node.
+func (e *editor) contentStart(n *yamlv3.Node) int {
+	return skipProperties(e.src, e.start(n))
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// nodeEnd returns the byte offset just past the text of n. The bound is
+// the offset of the next node in the document, and flow is true when n is
+// inside a flow collection.
+func (e *editor) nodeEnd(n *yamlv3.Node, bound int, flow bool) int {
+	i := e.contentStart(n)
+	switch n.Kind {
+	case yamlv3.AliasNode:
+		return i + 1 + len(n.Value)
+	case yamlv3.MappingNode, yamlv3.SequenceNode:
+		if n.Style&yamlv3.FlowStyle != 0 {
+			return flowEnd(e.src, i)
+		}
+		if len(n.Content) == 0 {
+			return i
+		}
+		return e.nodeEnd(n.Content[len(n.Content)-1], bound, false)
+	}
+	switch {
+	case n.Style&yamlv3.DoubleQuotedStyle != 0:
+		return quotedEnd(e.src, i)
+	case n.Style&yamlv3.SingleQuotedStyle != 0:
+		return quotedEnd(e.src, i)
+	case n.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0:
+		return blockScalarEnd(e.src[:bound], i, -1)
+	}
+	if n.Value == "" && n.Tag == "!!null" && e.start(n) == i {
+		// an empty value, like "key:"
+		if i < len(e.src) && e.src[i] != '~' && !strings.HasPrefix(e.src[i:], "null") &&
+			!strings.HasPrefix(e.src[i:], "Null") && !strings.HasPrefix(e.src[i:], "NULL") {
+			return i
+		}
+	}
+	end := plainEnd(e.src, i, flow)
+	if flow || e.src[i:end] == n.Value {
+		return end
+	}
+	// multi-line plain scalar
+	for j := lineEnd(e.src, end) + 1; j < len(e.src) && j < bound; {
+		k := lineEnd(e.src, j)
+		if k >= bound {
+			break
+		}
+		line := strings.TrimLeft(e.src[j:k], " \t")
+		if strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.TrimSpace(line) != "" {
+			end = plainEnd(e.src, k-len(line), false)
+		}
+		j = k + 1
+	}
+	return end
+}
+
+// editTarget is the position of a path inside the document.
+type editTarget struct {
+	// up is the target of the collection holding node, nil for the root.
+	up *editTarget
+	// parent is the collection holding node, nil for the root.
+	parent *yamlv3.Node
+	// pos is the index of node in parent.Content.
+	pos int
+	// node is the value at the path, nil when it does not exist yet.
+	node *yamlv3.Node
+	// bound is the offset of the node that follows node in the document.
+	bound int
+	// rest are the path components that do not exist yet.
+	rest []pathComponent
+}
+
+// find walks the path down the document. When only a prefix of the path
+// exists, the returned target points at the deepest existing collection,
+// or at a null value that can be replaced, and rest holds the remainder.
+func (e *editor) find(parts []pathComponent) (*editTarget, error) {
+	if e.root == nil {
+		return &editTarget{rest: parts}, nil
+	}
+	t := &editTarget{node: e.root, bound: e.end}
+	for i, part := range parts {
+		n := t.node
+		switch n.Kind {
+		case yamlv3.MappingNode:
+			j := mappingKey(n, part.key)
+			if j == -1 {
+				return &editTarget{up: t, parent: n, pos: -1, bound: t.bound, rest: parts[i:]}, nil
+			}
+			t = &editTarget{up: t, parent: n, pos: j + 1, node: n.Content[j+1], bound: e.next(n, j+2, t.bound)}
+		case yamlv3.SequenceNode:
+			if part.isSlice {
+				return nil, &PathError{Segment: part.segment, Reason: "only keys and indexes can be edited"}
+			}
+			if !part.isIndex {
+				return nil, &KeyError{Segment: part.segment, Reason: "value is a sequence, not a mapping"}
+			}
+			index := part.index
+			if index < 0 {
+				// a negative index counts from the end
+				index += len(n.Content)
+			}
+			if index == len(n.Content) {
+				return &editTarget{up: t, parent: n, pos: -1, bound: t.bound, rest: parts[i:]}, nil
+			}
+			if index < 0 || index > len(n.Content) {
+				return nil, &IndexError{Segment: part.segment, Index: part.index, Len: len(n.Content)}
+			}
+			t = &editTarget{up: t, parent: n, pos: index, node: n.Content[index], bound: e.next(n, index+1, t.bound)}
+		case yamlv3.AliasNode:
+			return nil, &KeyError{Segment: part.segment, Reason: "value is the alias *" + n.Value + ", which can't be edited through"}
+		default:
+			if n.Tag != "!!null" {
+				return nil, &KeyError{Segment: part.segment, Reason: "value is a scalar"}
+			}
+			t.rest = parts[i:]
+			return t, nil
+		}
+	}
+	return t, nil
+}
+
+// next returns the offset of n.Content[i], or bound when it does not exist.
+func (e *editor) next(n *yamlv3.Node, i, bound int) int {
+	if i < len(n.Content) {
+		return e.start(n.Content[i])
+	}
+	return bound
+}
+
+func mappingKey(n *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Kind == yamlv3.ScalarNode && n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func isFlow(n *yamlv3.Node) bool {
+	return n != nil && n.Style&yamlv3.FlowStyle != 0
+}
+
+func (e *editor) set(t *editTarget, frag fragment) (string, error) {
+	if len(t.rest) == 0 {
+		return e.replace(t, frag), nil
+	}
+	switch {
+	case t.node != nil:
+		// a null value, replaced by the new collection
+		f, err := frag.wrap(t.rest)
+		if err != nil {
+			return e.src, err
+		}
+		return e.replace(t, f), nil
+	case t.parent == nil:
+		// an empty document
+		f, err := frag.wrap(t.rest)
+		if err != nil {
+			return e.src, err
+		}
+		src := e.src
+		if strings.TrimSpace(src) == "" {
+			src = ""
+		} else if !strings.HasSuffix(src, "\n") {
+			src += "\n"
+		}
+		return src + f.text + "\n", nil
+	}
+	f, err := frag.wrap(t.rest[1:])
+	if err != nil {
+		return e.src, err
+	}
+	if t.parent.Kind == yamlv3.MappingNode {
+		return e.insertPair(t, t.rest[0].key, f), nil
+	}
+	return e.appendItem(t, f), nil
+}
+
+func (e *editor) splice(start, end int, text string) string {
+	return e.src[:start] + text + e.src[end:]
+}
+
+// column returns the number of bytes between the start of the line and i.
+func (e *editor) column(i int) int {
+	return i - lineStart(e.src, i)
+}
+
+// dash returns the offset of the "- " indicator in front of a sequence
+// item that starts at i.
+func (e *editor) dash(i int) int {
+	j := i - 1
+	for j >= 0 && e.src[j] == ' ' {
+		j--
+	}
+	if j >= 0 && e.src[j] == '-' {
+		return j
+	}
+	return i
+}
+
+// childIndent returns the indentation of the entries of a block
+// collection.
+func (e *editor) childIndent(n *yamlv3.Node) int {
+	i := e.start(n.Content[0])
+	if n.Kind == yamlv3.SequenceNode {
+		i = e.dash(i)
+	}
+	return e.column(i)
+}
+
+// replace overwrites the value of the target with the fragment.
+func (e *editor) replace(t *editTarget, f fragment) string {
+	n := t.node
+	start, end := e.start(n), e.nodeEnd(n, t.bound, isFlow(t.parent))
+	anchor := ""
+	if n.Anchor != "" {
+		anchor = "&" + n.Anchor
+	}
+	switch {
+	case isFlow(t.parent):
+		text := f.flow()
+		if anchor != "" {
+			text = anchor + " " + text
+		}
+		return e.splice(start, end, text)
+	case t.parent == nil:
+		text := f.text
+		if anchor != "" && f.block {
+			text = anchor + "\n" + text
+		} else if anchor != "" {
+			text = anchor + " " + text
+		}
+		return e.splice(start, end, text)
+	case t.parent.Kind == yamlv3.MappingNode:
+		key := t.parent.Content[t.pos-1]
+		indent := e.column(e.start(key))
+		if f.block && isBlockCollection(n) && n.Content[0].Line > key.Line {
+			// keep the key line, including its anchor and comments
+			return e.splice(lineStart(e.src, e.start(n.Content[0])), end, indentLines(f.text, e.childIndent(n), true))
+		}
+		start = e.colon(key, start)
+		text := placeValue(f, indent, indent+2)
+		if anchor != "" {
+			text = " " + anchor + text
+		}
+		return e.splice(start, end, text)
+	default:
+		indent := e.column(e.dash(start)) + 2
+		if anchor != "" && f.block {
+			return e.splice(start, end, anchor+"\n"+indentLines(f.text, indent, true))
+		}
+		text := placeInline(f, indent)
+		if anchor != "" {
+			text = anchor + " " + text
+		}
+		return e.splice(start, end, text)
+	}
+}
+
+// colon returns the offset just past the ':' that follows a mapping key.
+func (e *editor) colon(key *yamlv3.Node, bound int) int {
+	i := e.nodeEnd(key, bound, false)
+	for i < bound && e.src[i] != ':' {
+		i++
+	}
+	if i < bound {
+		i++
+	}
+	return i
+}
+
+// insertPair adds a new key to the end of the target mapping.
+func (e *editor) insertPair(t *editTarget, key string, f fragment) string {
+	m := t.parent
+	if isFlow(m) {
+		pair := renderKey(key) + ": " + f.flow()
+		if len(m.Content) == 0 {
+			at := e.contentStart(m) + 1
+			return e.splice(at, at, pair)
+		}
+		at := e.nodeEnd(m.Content[len(m.Content)-1], t.bound, true)
+		return e.splice(at, at, ", "+pair)
+	}
+	indent := e.childIndent(m)
+	at := lineEnd(e.src, e.nodeEnd(m.Content[len(m.Content)-1], t.bound, false))
+	text := "\n" + strings.Repeat(" ", indent) + renderKey(key) + ":" + placeValue(f, indent, indent+2)
+	return e.splice(at, at, text)
+}
+
+// appendItem adds a new item to the end of the target sequence.
+func (e *editor) appendItem(t *editTarget, f fragment) string {
+	s := t.parent
+	if isFlow(s) {
+		if len(s.Content) == 0 {
+			at := e.contentStart(s) + 1
+			return e.splice(at, at, f.flow())
+		}
+		at := e.nodeEnd(s.Content[len(s.Content)-1], t.bound, true)
+		return e.splice(at, at, ", "+f.flow())
+	}
+	indent := e.childIndent(s)
+	at := lineEnd(e.src, e.nodeEnd(s.Content[len(s.Content)-1], t.bound, false))
+	text := "\n" + strings.Repeat(" ", indent) + "- " + placeInline(f, indent+2)
+	return e.splice(at, at, text)
+}
+
+// delete removes the target from its collection. Removing the last entry
+// leaves an empty flow collection behind instead of a null.
+func (e *editor) delete(t *editTarget) string {
+	p := t.parent
+	if p == nil {
+		return e.splice(e.start(t.node), e.nodeEnd(t.node, t.bound, false), "")
+	}
+	step, first := 1, t.pos
+	if p.Kind == yamlv3.MappingNode {
+		step, first = 2, t.pos-1
+	}
+	if len(p.Content) == step {
+		empty := fragment{text: "[]"}
+		if p.Kind == yamlv3.MappingNode {
+			empty.text = "{}"
+		}
+		return e.replace(t.up, empty)
+	}
+	last := first+step == len(p.Content)
+	if isFlow(p) {
+		if !last {
+			return e.splice(e.start(p.Content[first]), e.start(p.Content[first+step]), "")
+		}
+		prev := e.nodeEnd(p.Content[first-1], e.start(p.Content[first]), true)
+		return e.splice(prev, e.nodeEnd(t.node, t.bound, true), "")
+	}
+	start := e.start(p.Content[first])
+	if p.Kind == yamlv3.SequenceNode {
+		start = e.dash(start)
+	}
+	if ls := lineStart(e.src, start); strings.TrimSpace(e.src[ls:start]) == "" {
+		end := lineEnd(e.src, e.nodeEnd(t.node, t.bound, false))
+		if end < len(e.src) {
+			end++
+		}
+		return e.splice(ls, end, "")
+	}
+	// a compact entry that shares its line with the parent, like the
+	// first key in "- a: 1"
+	next := e.start(p.Content[first+step])
+	if p.Kind == yamlv3.SequenceNode {
+		next = e.dash(next)
+	}
+	return e.splice(start, next, "")
+}
@@ -0,0 +1,416 @@
+package gyaml
+
+import (
+	"testing"
+)
+
+const testEditYAML = `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path     string
+		value    interface{}
+		expected string
+	}{
+		{"name.first", "Jim", `# service config
+name:
+  first: Jim   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"name.middle", "yes", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+  middle: "yes"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"children.2", "Jack", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+  - Jack
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"age", map[string]int{"years": 37}, `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age:
+  years: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"flow.b.2", "z", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y, z]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"friends.1.age", 68, `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+    age: 68
+`},
+		{"owner.contact.0", "ops", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+owner:
+  contact:
+    - ops
+`},
+	}
+
+	for _, tt := range tests {
+		res, err := Set(testEditYAML, tt.path, tt.value)
+		if err != nil {
+			t.Errorf("Set(%q) error: %v", tt.path, err)
+			continue
+		}
+		if res != tt.expected {
+			t.Errorf("Set(%q) =\n%s\nwant\n%s", tt.path, res, tt.expected)
+		}
+		if Get(res, tt.path).String() == "" {
+			t.Errorf("Get(%q) after Set is empty", tt.path)
+		}
+	}
+}
+
+func TestSetRaw(t *testing.T) {
+	res, err := SetRaw(testEditYAML, "friends.1", "first: Jane\nlast: Murphy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Get(res, "friends.1.first").String() != "Jane" {
+		t.Errorf("friends.1.first = %q, want %q", Get(res, "friends.1.first").String(), "Jane")
+	}
+	if Get(res, "name.last").Raw != `"Anderson"` {
+		t.Errorf("quoting of name.last was not preserved")
+	}
+
+	res, err = SetRaw(testEditYAML, "flow.c", "x: 1\ny: 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Valid(res) || Get(res, "flow.c.y").Int() != 2 {
+		t.Errorf("SetRaw into flow mapping = %q", res)
+	}
+
+	if _, err := SetRaw(testEditYAML, "name", "invalid: yaml: ["); err == nil {
+		t.Error("SetRaw with invalid yaml should fail")
+	}
+}
+
+func TestSetEmptyDocument(t *testing.T) {
+	res, err := Set("", "a.b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a:\n  b: 1\n" {
+		t.Errorf("Set on empty document = %q", res)
+	}
+
+	res, err = Set("a:\n", "a.b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a:\n  b: 1\n" {
+		t.Errorf("Set on null value = %q", res)
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	for _, path := range []string{"", "children.5", "children.-3", "children.x", "age.years", "friends.#.first", "children.0:1"} {
+		if _, err := Set(testEditYAML, path, 1); err == nil {
+			t.Errorf("Set(%q) should fail", path)
+		}
+	}
+
+	for _, path := range []string{"", "name.*", "children|@reverse", "friends.#.first",
+		"**.first", "friends..first", "{name,age}", "[name,age]"} {
+		res, err := Set(testEditYAML, path, 1)
+		if e, ok := err.(*PathError); !ok || e.Path != path {
+			t.Errorf("Set(%q) error = %v, want a *PathError", path, err)
+		}
+		if res != testEditYAML {
+			t.Errorf("Set(%q) changed the yaml", path)
+		}
+		if _, err := Delete(testEditYAML, path); err == nil {
+			t.Errorf("Delete(%q) should fail", path)
+		} else if _, ok := err.(*PathError); !ok {
+			t.Errorf("Delete(%q) error %T, want *PathError", path, err)
+		}
+	}
+	for _, path := range []string{"children.5", "children.-3", "name.nick.1"} {
+		if _, err := Set(testEditYAML, path, 1); err == nil {
+			t.Errorf("Set(%q) should fail", path)
+		} else if e, ok := err.(*IndexError); !ok || e.Path != path {
+			t.Errorf("Set(%q) error = %v, want an *IndexError", path, err)
+		}
+	}
+	for _, path := range []string{"children.x", "age.years"} {
+		if _, err := Set(testEditYAML, path, 1); err == nil {
+			t.Errorf("Set(%q) should fail", path)
+		} else if e, ok := err.(*KeyError); !ok || e.Path != path {
+			t.Errorf("Set(%q) error = %v, want a *KeyError", path, err)
+		}
+	}
+	if _, err := Set("a: [\n", "a", 1); err == nil {
+		t.Error("Set on invalid yaml should fail")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Set on invalid yaml error %T, want *SyntaxError", err)
+	}
+	if _, err := SetRaw(testEditYAML, "name", "invalid: yaml: ["); err == nil {
+		t.Error("SetRaw with invalid yaml should fail")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("SetRaw with invalid yaml error %T, want *SyntaxError", err)
+	}
+}
+
+func TestSetSliceKeys(t *testing.T) {
+	yaml := "times:\n  \"12:30\": lunch\n"
+	res, err := Set(yaml, "times.12:30", "brunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "times:\n  \"12:30\": brunch\n" {
+		t.Errorf("Set(times.12:30) = %q", res)
+	}
+	res, err = Set(yaml, "times.1:2", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Get(res, "times.1\\:2").String(); got != "x" {
+		t.Errorf("times.1:2 = %q, want x", got)
+	}
+	res, err = Set("", "a.1:2", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a:\n  \"1:2\": b\n" {
+		t.Errorf("Set(a.1:2) = %q", res)
+	}
+	res, err = Delete(yaml, "times.12:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "times: {}\n" {
+		t.Errorf("Delete(times.12:30) = %q", res)
+	}
+
+	if _, err := Set(testEditYAML, "children.0:1", 1); err == nil {
+		t.Error("Set(children.0:1) should fail")
+	} else if e, ok := err.(*PathError); !ok || e.Segment != "0:1" {
+		t.Errorf("Set(children.0:1) error = %v, want a *PathError", err)
+	}
+}
+
+func TestSetNegativeIndex(t *testing.T) {
+	res, err := Set(testEditYAML, "friends.-1.last", "Smith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Get(res, "friends.1.last").String(); got != "Smith" {
+		t.Errorf("friends.1.last = %q, want Smith", got)
+	}
+	res, err = Delete(testEditYAML, "children.-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Get(res, "children").Array(); len(got) != 1 || got[0].String() != "Alex" {
+		t.Errorf("children = %v, want [Alex]", got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"name.last", `# service config
+name:
+  first: Tom   # given name
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"script", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+flow: {a: 1, b: [x, y]}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"friends.0.first", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1, b: [x, y]}
+friends:
+  - last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"flow.b", `# service config
+name:
+  first: Tom   # given name
+  last: "Anderson"
+age: 37
+children:
+  - Sara
+  - Alex
+script: |
+  echo hi
+flow: {a: 1}
+friends:
+  - first: Dale
+    last: Murphy
+  - first: Roger
+    last: Craig
+`},
+		{"does.not.exist", testEditYAML},
+	}
+
+	for _, tt := range tests {
+		res, err := Delete(testEditYAML, tt.path)
+		if err != nil {
+			t.Errorf("Delete(%q) error: %v", tt.path, err)
+			continue
+		}
+		if res != tt.expected {
+			t.Errorf("Delete(%q) =\n%s\nwant\n%s", tt.path, res, tt.expected)
+		}
+	}
+}
+
+func TestDeleteLastEntry(t *testing.T) {
+	res, err := Delete("a:\n  b: 1\nc:\n  - 2\n", "a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = Delete(res, "c.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a: {}\nc: []\n" {
+		t.Errorf("Delete last entries = %q", res)
+	}
+}